@@ -0,0 +1,184 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// Adds a batch of tasks, spread over the shards in contiguous chunks that
+// are each enqueued under a single lock. Tasks are accepted in order: on
+// error, tasks[:accepted] were accepted and tasks[accepted:] were not. The
+// error is ErrPoolOverload if all shards are full, or ErrPoolStopped.
+//
+// With a key func set (see SetKeyFunc), every task goes to the shard of its
+// key, one by one. With a rejection policy (see SetRejectionPolicy), the
+// tasks that don't fit are added one by one under that policy.
+func (wp *WorkerPool[T]) AddTasks(tasks []T) (accepted int, err error) {
+	// Under a rejection policy, submit counts the rejections of the tasks
+	// that don't fit. Keyed tasks are added one by one anyway.
+	abort := wp.rejection.Action == RejectAbort
+	if abort || wp.keyFunc == nil {
+		accepted, err = wp.addTasks(laneNormal, tasks, abort)
+		if err != ErrPoolOverload || abort {
+			return accepted, err
+		}
+	}
+
+	for _, task := range tasks[accepted:] {
+		if err := wp.submit(laneNormal, task); err != nil {
+			return accepted, err
+		}
+		accepted++
+	}
+
+	return accepted, nil
+}
+
+// Adds a batch of tasks and blocks until all of them are submitted. On
+// error, tasks[:accepted] were accepted (see AddTasks).
+func (wp *WorkerPool[T]) AddTasksWithBlocking(tasks []T) (accepted int, err error) {
+	return wp.AddTasksWithBlockingContext(context.Background(), tasks)
+}
+
+// Adds a batch of tasks and blocks until all of them are submitted, ctx is
+// done or the pool is stopped. Returns ctx.Err() on cancellation and
+// ErrPoolStopped if the pool stops while waiting; tasks[:accepted] were
+// accepted nonetheless.
+func (wp *WorkerPool[T]) AddTasksWithBlockingContext(ctx context.Context, tasks []T) (accepted int, err error) {
+	err = wp.blockOnOverload(ctx, func() error {
+		n, err := wp.addTasks(laneNormal, tasks[accepted:], false)
+		accepted += n
+		return err
+	})
+
+	return accepted, err
+}
+
+// addTasks adds a batch of tasks to the given priority lane; returns the
+// number of tasks accepted, which are always a prefix of the batch. The
+// tasks that are not accepted only count as rejected if countRejected is set.
+func (wp *WorkerPool[T]) addTasks(lane int, tasks []T, countRejected bool) (int, error) {
+	if !wp.started {
+		return 0, errors.New("worker pool must be started first")
+	}
+	if atomic.LoadInt32(&wp.stopped) != 0 {
+		return 0, ErrPoolStopped
+	}
+	if wp.keyFunc != nil {
+		for i, task := range tasks {
+			if err := wp.addTaskKeyed(lane, wp.keyFunc(task), task, countRejected); err != nil {
+				return i, err
+			}
+		}
+		return len(tasks), nil
+	}
+
+	accepted := 0
+	for accepted < len(tasks) {
+		// Start at a random shard and split the remaining tasks evenly over
+		// the shards not tried yet, so that the tasks a full shard refused
+		// spill over to the others.
+		shards := wp.shards.Load().shards
+		n := len(shards)
+		start := randInt()
+		var last *poolShard[T]
+		for i := 0; i < n && accepted < len(tasks); i++ {
+			last = shards[(start+i)%n]
+			remaining := len(tasks) - accepted
+			chunk := (remaining + n - i - 1) / (n - i)
+
+			k, err := last.dispatchBatch(lane, tasks[accepted:accepted+chunk])
+			accepted += k
+			if err == errShardRemoved {
+				// retry the rest on the current shard table
+				last = nil
+				break
+			}
+			if err != nil && err != ErrPoolOverload {
+				return accepted, err
+			}
+		}
+
+		if last != nil && accepted < len(tasks) {
+			if countRejected {
+				atomic.AddUint64(&last.rejected, uint64(len(tasks)-accepted))
+			}
+			return accepted, ErrPoolOverload
+		}
+	}
+
+	return accepted, nil
+}
+
+// dispatchBatch enqueues tasks in order into the given priority lane until
+// the queue is full, spawning workers on visible backlog like dispatch. The
+// RLock is taken once for the whole batch. Returns the number of tasks
+// enqueued and ErrPoolOverload if that is less than all of them; rejections
+// are not counted.
+func (shard *poolShard[T]) dispatchBatch(lane int, tasks []T) (int, error) {
+	queue := shard.laneQueue(lane)
+
+	shard.tqLock.RLock()
+
+	if atomic.LoadInt32(&shard.wp.stopped) != 0 {
+		shard.tqLock.RUnlock()
+		return 0, ErrPoolStopped
+	}
+	if shard.removed {
+		shard.tqLock.RUnlock()
+		return 0, errShardRemoved
+	}
+
+	// The tasks share their meta data.
+	var meta *taskMeta
+	if shard.timing != nil {
+		meta = &taskMeta{enqueuedAt: nanotime()}
+	}
+
+	// Count the tasks before they become visible to workers, so completed
+	// can never overtake submitted.
+	atomic.AddUint64(&shard.submitted, uint64(len(tasks)))
+
+	sent := 0
+	canSpawn := true
+	for sent < len(tasks) {
+		qt := QueuedTask[T]{task: tasks[sent], meta: meta}
+		if shard.send(queue, lane, qt) {
+			sent++
+			if canSpawn && shard.hasBacklog(queue) {
+				canSpawn = shard.trySpawnWorker()
+			}
+			continue
+		}
+
+		// buffer full — spawn and retry once
+		if !shard.trySpawnWorker() {
+			canSpawn = false
+		}
+		if shard.send(queue, lane, qt) {
+			sent++
+			continue
+		}
+		break
+	}
+
+	if !canSpawn && shard.wake != nil {
+		shard.nudgeSibling()
+	}
+
+	if sent == len(tasks) {
+		shard.tqLock.RUnlock()
+		return sent, nil
+	}
+
+	// Settle the counters while still holding the lock, so they are final
+	// once a removed shard has drained.
+	submitted := atomic.AddUint64(&shard.submitted, ^uint64(len(tasks)-sent-1))
+	shard.tqLock.RUnlock()
+	shard.checkIdle(submitted, atomic.LoadUint64(&shard.completed))
+	return sent, ErrPoolOverload
+}
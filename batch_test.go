@@ -0,0 +1,151 @@
+package ultrapool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAddTasks(t *testing.T) {
+	const numTasks = 1000
+
+	var sum int64
+	wp := NewWorkerPool(func(task int) {
+		atomic.AddInt64(&sum, int64(task))
+	})
+	wp.SetNumShards(4)
+	wp.Start()
+
+	tasks := make([]int, numTasks)
+	for i := range tasks {
+		tasks[i] = i + 1
+	}
+	accepted, err := wp.AddTasks(tasks)
+	if err != nil || accepted != numTasks {
+		t.Fatalf("AddTasks: got %d, %v, want %d, <nil>", accepted, err, numTasks)
+	}
+	if accepted, err := wp.AddTasks(nil); err != nil || accepted != 0 {
+		t.Errorf("AddTasks(nil): got %d, %v, want 0, <nil>", accepted, err)
+	}
+	wp.StopAndWait()
+
+	if want := int64(numTasks * (numTasks + 1) / 2); sum != want {
+		t.Errorf("sum of tasks run: got %d, want %d", sum, want)
+	}
+	for i, ss := range wp.Stats().Shards {
+		if ss.Completed == 0 {
+			t.Errorf("shard %d got no tasks", i)
+		}
+	}
+
+	if _, err := wp.AddTasks(tasks); err != ErrPoolStopped {
+		t.Errorf("AddTasks on a stopped pool: got %v, want ErrPoolStopped", err)
+	}
+}
+
+// blockedShardsPool returns a started pool with one worker per shard that is
+// blocked until release is closed. The handler records the other tasks.
+func blockedShardsPool(t *testing.T, numShards, queueSize int) (wp *WorkerPool[int], ran *sync.Map, release chan struct{}) {
+	t.Helper()
+
+	ran = &sync.Map{}
+	release = make(chan struct{})
+	var running int32
+	wp = NewWorkerPool(func(task int) {
+		if task < 0 {
+			atomic.AddInt32(&running, 1)
+			<-release
+			return
+		}
+		ran.Store(task, true)
+	})
+	wp.SetNumShards(numShards)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.SetQueueSize(queueSize)
+	wp.Start()
+
+	for _, shard := range wp.shards.Load().shards {
+		if err := shard.dispatch(laneNormal, -1); err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&running) < int32(numShards) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&running); got != int32(numShards) {
+		t.Fatalf("workers never fully engaged: running=%d, want %d", got, numShards)
+	}
+
+	return wp, ran, release
+}
+
+func TestAddTasksPartial(t *testing.T) {
+	const numShards = 3
+	const queueSize = 16
+	const capacity = numShards * queueSize
+
+	wp, ran, release := blockedShardsPool(t, numShards, queueSize)
+
+	tasks := make([]int, capacity+5)
+	for i := range tasks {
+		tasks[i] = i
+	}
+	accepted, err := wp.AddTasks(tasks)
+	if accepted != capacity || err != ErrPoolOverload {
+		t.Errorf("AddTasks: got %d, %v, want %d, ErrPoolOverload", accepted, err, capacity)
+	}
+	if got := wp.Stats().Rejected; got != 5 {
+		t.Errorf("rejected tasks: got %d, want 5", got)
+	}
+
+	// Exactly the accepted prefix runs.
+	close(release)
+	wp.StopAndWait()
+	for i, task := range tasks {
+		_, ok := ran.Load(task)
+		if ok != (i < accepted) {
+			t.Errorf("task %d: ran=%v, want %v", i, ok, i < accepted)
+		}
+	}
+}
+
+func TestAddTasksWithBlocking(t *testing.T) {
+	const numShards = 2
+	const queueSize = 16
+
+	wp, ran, release := blockedShardsPool(t, numShards, queueSize)
+
+	tasks := make([]int, 5*numShards*queueSize)
+	for i := range tasks {
+		tasks[i] = i
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	accepted, err := wp.AddTasksWithBlockingContext(ctx, tasks)
+	if accepted != numShards*queueSize || err != context.DeadlineExceeded {
+		t.Errorf("AddTasksWithBlockingContext: got %d, %v, want %d, context.DeadlineExceeded", accepted, err, numShards*queueSize)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := wp.AddTasksWithBlocking(tasks[accepted:])
+		if n != len(tasks)-accepted || err != nil {
+			t.Errorf("AddTasksWithBlocking: got %d, %v, want %d, <nil>", n, err, len(tasks)-accepted)
+		}
+	}()
+	close(release)
+	<-done
+	wp.StopAndWait()
+
+	for _, task := range tasks {
+		if _, ok := ran.Load(task); !ok {
+			t.Errorf("task %d did not run", task)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// BatchHandlerFunc handles a batch of tasks. The slice belongs to the worker
+// and is reused for its next batch, so it must not be retained after the
+// handler returns.
+type BatchHandlerFunc[T any] func(batch []T)
+
+// batching is the configuration of batched execution mode
+type batching[T any] struct {
+	handlerFunc BatchHandlerFunc[T]
+	maxBatch    int
+	maxLinger   time.Duration
+}
+
+// Creates a new WorkerPool that runs tasks in batches, for handlers that are
+// more efficient on many tasks at once (bulk inserts, vectored writes). A
+// worker that takes a task from its shard's queue also takes the tasks queued
+// behind it, up to maxBatch in total. If that leaves the batch short, it
+// waits up to maxLinger for more (0 means not at all) and then passes the
+// batch to handlerFunc.
+//
+// Lingering ends early once the pool is stopped, so Stop still drains every
+// accepted task. Keyed tasks in key serialization mode are only batched with
+// other tasks, not with their successors of the same key. The panic handler
+// receives the first task of a panicking batch; task hooks and timing mode
+// report every task of a batch with the batch's execution time. Stats
+// reports the distribution of batch sizes.
+func NewBatchWorkerPool[T any](handlerFunc BatchHandlerFunc[T], maxBatch int, maxLinger time.Duration) *WorkerPool[T] {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	if maxLinger < 0 {
+		maxLinger = 0
+	}
+
+	wp := NewWorkerPool[T](nil)
+	wp.batching = &batching[T]{
+		handlerFunc: handlerFunc,
+		maxBatch:    maxBatch,
+		maxLinger:   maxLinger,
+	}
+
+	return wp
+}
+
+// taskBatch is the batch of a single worker
+type taskBatch[T any] struct {
+	*batching[T]
+	queued []QueuedTask[T]
+	tasks  []T
+	runAll TaskHandlerFunc[T] // runs tasks; the argument is ignored
+	timer  *time.Timer        // linger timer, created on first use
+	turn   int                // lane schedule position in priority mode
+}
+
+func (b *batching[T]) newBatch() *taskBatch[T] {
+	batch := &taskBatch[T]{
+		batching: b,
+		queued:   make([]QueuedTask[T], 0, b.maxBatch),
+		tasks:    make([]T, 0, b.maxBatch),
+	}
+	batch.runAll = func(T) {
+		batch.handlerFunc(batch.tasks)
+	}
+
+	return batch
+}
+
+// runOne runs a single task as a batch of one
+func (batch *taskBatch[T]) runOne(task T) {
+	batch.tasks = append(batch.tasks[:0], task)
+	batch.handlerFunc(batch.tasks)
+	batch.reset()
+}
+
+// reset clears the batch, so it doesn't keep tasks reachable
+func (batch *taskBatch[T]) reset() {
+	var zero T
+	for i := range batch.tasks {
+		batch.tasks[i] = zero
+	}
+	for i := range batch.queued {
+		batch.queued[i] = QueuedTask[T]{}
+	}
+	batch.tasks = batch.tasks[:0]
+	batch.queued = batch.queued[:0]
+}
+
+// collect fills the batch with first and the tasks queued behind it in the
+// shard, lingering for more if it is short. It returns right away once the
+// queue is closed.
+func (batch *taskBatch[T]) collect(shard *poolShard[T], first QueuedTask[T]) {
+	batch.queued = append(batch.queued, first)
+
+	for len(batch.queued) < batch.maxBatch {
+		qt, ok, closed := shard.poll(&batch.turn)
+		if closed {
+			return
+		}
+		if !ok {
+			break
+		}
+		batch.queued = append(batch.queued, qt)
+	}
+	if len(batch.queued) == batch.maxBatch || batch.maxLinger == 0 {
+		return
+	}
+
+	if batch.timer == nil {
+		batch.timer = time.NewTimer(batch.maxLinger)
+	} else {
+		batch.timer.Reset(batch.maxLinger)
+	}
+	for len(batch.queued) < batch.maxBatch {
+		qt, ok, timedOut := shard.waitUntil(batch.timer.C)
+		if timedOut {
+			return
+		}
+		if !ok {
+			break
+		}
+		batch.queued = append(batch.queued, qt)
+	}
+	stopTimer(batch.timer)
+}
+
+// poll takes a task from the shard's queue without blocking
+func (shard *poolShard[T]) poll(turn *int) (qt QueuedTask[T], ok bool, closed bool) {
+	if shard.queues != nil {
+		return shard.queues.poll(turn)
+	}
+
+	select {
+	case qt, ok = <-shard.taskQueue:
+		return qt, ok, !ok
+	default:
+		return qt, false, false
+	}
+}
+
+// waitUntil takes a task from the shard's queue, blocking until one arrives,
+// the queue is closed (ok is false) or timeout fires (timedOut)
+func (shard *poolShard[T]) waitUntil(timeout <-chan time.Time) (qt QueuedTask[T], ok bool, timedOut bool) {
+	if shard.queues != nil {
+		return shard.queues.wait(timeout, nil)
+	}
+
+	select {
+	case qt, ok = <-shard.taskQueue:
+	case <-timeout:
+		timedOut = true
+	}
+
+	return qt, ok, timedOut
+}
+
+// runBatch collects a batch starting with first, runs it and accounts for
+// the completion of all its tasks
+func (shard *poolShard[T]) runBatch(handler TaskHandlerFunc[T], batch *taskBatch[T], first QueuedTask[T]) {
+	wp := shard.wp
+	batch.collect(shard, first)
+	n := len(batch.queued)
+
+	// The successors of keyed tasks run one by one afterwards, even if
+	// their predecessor is skipped; they reuse the batch.
+	var keys []uint64
+	for _, qt := range batch.queued {
+		if key, keyed := qt.key(); keyed {
+			keys = append(keys, key)
+		}
+	}
+
+	batch.dropCanceled()
+	if len(batch.queued) > 0 {
+		shard.execBatch(batch)
+	}
+
+	shard.batches.record(int64(n))
+	completed := atomic.AddUint64(&shard.completed, uint64(n))
+	shard.checkIdle(atomic.LoadUint64(&shard.submitted), completed)
+
+	batch.reset()
+	for _, key := range keys {
+		wp.keys.runBacklog(handler, key)
+	}
+}
+
+// dropCanceled removes the tasks of canceled groups from the batch
+func (batch *taskBatch[T]) dropCanceled() {
+	kept := batch.queued[:0]
+	for _, qt := range batch.queued {
+		if group := qt.group(); group == nil || !group.skip() {
+			kept = append(kept, qt)
+		}
+	}
+	for i := len(kept); i < len(batch.queued); i++ {
+		batch.queued[i] = QueuedTask[T]{}
+	}
+	batch.queued = kept
+}
+
+// execBatch runs the collected tasks in a single handler call
+func (shard *poolShard[T]) execBatch(batch *taskBatch[T]) {
+	wp := shard.wp
+	for _, qt := range batch.queued {
+		batch.tasks = append(batch.tasks, qt.task)
+	}
+
+	observed := shard.timing != nil || wp.hooks != nil
+	var start int64
+	if observed {
+		start = nanotime()
+		for _, qt := range batch.queued {
+			if shard.timing != nil {
+				shard.timing.queueWait.record(start - qt.enqueuedAt())
+			}
+			if wp.hooks != nil && wp.hooks.OnTaskStart != nil {
+				wp.hooks.OnTaskStart(shard.index, qt.task)
+			}
+		}
+	}
+
+	recovered := wp.execute(batch.runAll, batch.tasks[0])
+
+	if observed {
+		duration := nanotime() - start
+		for _, qt := range batch.queued {
+			if shard.timing != nil {
+				shard.timing.execution.record(duration)
+			}
+			if wp.hooks != nil && wp.hooks.OnTaskDone != nil {
+				wp.hooks.OnTaskDone(shard.index, qt.task, time.Duration(duration), recovered)
+			}
+		}
+	}
+
+	for _, qt := range batch.queued {
+		if group := qt.group(); group != nil {
+			group.finish(recovered)
+		}
+	}
+}
+
+// BatchStats summarizes the sizes of the batches run in batched execution
+// mode (see NewBatchWorkerPool).
+type BatchStats struct {
+	Count uint64 // batches run
+	Sum   uint64 // tasks run in batches
+	P50   int
+	P90   int
+	P99   int
+
+	hist *histogramSnapshot
+}
+
+func newBatchStats(s *histogramSnapshot) BatchStats {
+	return BatchStats{
+		Count: s.count,
+		Sum:   s.sum,
+		P50:   int(s.quantile(0.5)),
+		P90:   int(s.quantile(0.9)),
+		P99:   int(s.quantile(0.99)),
+		hist:  s,
+	}
+}
+
+// Returns the batch size at or below which a fraction q (0..1) of the
+// batches fall; exact for sizes below 16 and accurate to 6.25% above.
+func (bs BatchStats) Quantile(q float64) int {
+	if bs.hist == nil {
+		return 0
+	}
+
+	return int(bs.hist.quantile(q))
+}
+
+// Returns the average batch size.
+func (bs BatchStats) Mean() float64 {
+	if bs.Count == 0 {
+		return 0
+	}
+
+	return float64(bs.Sum) / float64(bs.Count)
+}
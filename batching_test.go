@@ -0,0 +1,166 @@
+package ultrapool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchRecorder records the batches a pool runs; a batch containing -1
+// blocks until release is closed.
+type batchRecorder struct {
+	mutex   sync.Mutex
+	batches [][]int
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func newBatchRecorder() *batchRecorder {
+	return &batchRecorder{
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (br *batchRecorder) handle(batch []int) {
+	br.mutex.Lock()
+	br.batches = append(br.batches, append([]int(nil), batch...))
+	br.mutex.Unlock()
+
+	for _, task := range batch {
+		if task == -1 {
+			close(br.blocked)
+			<-br.release
+		}
+	}
+}
+
+func (br *batchRecorder) sizes() []int {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+
+	sizes := make([]int, len(br.batches))
+	for i, batch := range br.batches {
+		sizes[i] = len(batch)
+	}
+
+	return sizes
+}
+
+func TestBatchWorkerPool(t *testing.T) {
+	const maxBatch = 8
+	const numTasks = 20
+
+	br := newBatchRecorder()
+	wp := NewBatchWorkerPool(br.handle, maxBatch, 0)
+	wp.SetNumShards(1)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.Start()
+
+	if err := wp.AddTask(-1); err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+	<-br.blocked
+	for i := 0; i < numTasks; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask(%d): %v", i, err)
+		}
+	}
+	close(br.release)
+	wp.StopAndWait()
+
+	want := []int{1, 8, 8, 4}
+	got := br.sizes()
+	if len(got) != len(want) {
+		t.Fatalf("batch sizes: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("batch sizes: got %v, want %v", got, want)
+		}
+	}
+
+	next := 0
+	for _, batch := range br.batches[1:] {
+		for _, task := range batch {
+			if task != next {
+				t.Fatalf("task %d ran as #%d", task, next)
+			}
+			next++
+		}
+	}
+
+	stats := wp.Stats()
+	if stats.Completed != numTasks+1 {
+		t.Errorf("completed tasks: got %d, want %d", stats.Completed, numTasks+1)
+	}
+	bs := stats.BatchSize
+	if bs.Count != 4 || bs.Sum != numTasks+1 || bs.P50 != 4 || bs.P99 != 8 {
+		t.Errorf("batch stats: got count=%d sum=%d p50=%d p99=%d, want 4, %d, 4, 8", bs.Count, bs.Sum, bs.P50, bs.P99, numTasks+1)
+	}
+	if got := stats.Shards[0].BatchSize.Count; got != 4 {
+		t.Errorf("shard batch count: got %d, want 4", got)
+	}
+}
+
+func TestBatchWorkerPoolLinger(t *testing.T) {
+	br := newBatchRecorder()
+	wp := NewBatchWorkerPool(br.handle, 10, 200*time.Millisecond)
+	wp.SetNumShards(1)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.Start()
+
+	for i := 0; i < 3; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask(%d): %v", i, err)
+		}
+	}
+	wp.Wait()
+	if got := br.sizes(); len(got) != 1 || got[0] != 3 {
+		t.Errorf("batch sizes: got %v, want [3]", got)
+	}
+
+	// Stop ends lingering right away and still runs the batch.
+	br.mutex.Lock()
+	br.batches = nil
+	br.mutex.Unlock()
+	if err := wp.AddTask(3); err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	wp.StopAndWait()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("StopAndWait took %v while lingering", elapsed)
+	}
+	if got := br.sizes(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("batch sizes after Stop: got %v, want [1]", got)
+	}
+}
+
+func TestBatchWorkerPoolReusesSlice(t *testing.T) {
+	var mutex sync.Mutex
+	seen := make(map[*int]bool)
+	wp := NewBatchWorkerPool(func(batch []int) {
+		mutex.Lock()
+		seen[&batch[0]] = true
+		mutex.Unlock()
+	}, 4, 0)
+	wp.SetNumShards(1)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.Start()
+
+	for i := 0; i < 100; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking: %v", err)
+		}
+	}
+	wp.StopAndWait()
+
+	if len(seen) != 1 {
+		t.Errorf("the worker used %d batch slices, want 1", len(seen))
+	}
+}
@@ -393,6 +393,65 @@ func BenchmarkUltrapoolWorkerpool(b *testing.B) {
 	}
 }
 
+// BenchmarkUltrapoolQueueKinds compares the shard queue implementations
+// (see ultrapool.QueueKind) on the regular workloads.
+func BenchmarkUltrapoolQueueKinds(b *testing.B) {
+	queueKinds := []ultrapool.QueueKind{
+		ultrapool.QueueChan,
+		ultrapool.QueueRing,
+		ultrapool.QueueUnbounded,
+		ultrapool.QueueStack,
+	}
+
+	for _, info := range workLoads {
+
+		runtime.GC()
+
+		workLoadHandler = info.handler
+		for _, kind := range queueKinds {
+			for _, parallelism := range parellelisms {
+				b.Run(fmt.Sprintf("%s/%s/%d", info.name, kind, parallelism), func(b *testing.B) {
+
+					wp := ultrapool.NewWorkerPool(taskHandler)
+					wp.SetIdleWorkerLifetime(time.Second * 15)
+					wp.SetQueueKind(kind)
+
+					wp.Start()
+
+					stopSampler := startRuntimeSampler(func() int { return wp.GetSpawnedWorkers() })
+
+					b.ResetTimer()
+
+					b.ReportAllocs()
+					b.SetParallelism(parallelism)
+					b.RunParallel(func(pb *testing.PB) {
+						for pb.Next() {
+							wg.Add(1)
+							c := new(net.TCPConn)
+							if err := wp.AddTaskWithBlocking(c); err != nil {
+								wg.Done()
+							}
+						}
+					})
+
+					wp.Stop()
+					wg.Wait()
+					_, peakWorkers := stopSampler()
+					b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/sec")
+					b.ReportMetric(float64(peakWorkers), "peak-workers")
+
+					b.StopTimer()
+
+					runtime.GC()
+					time.Sleep(100 * time.Millisecond)
+
+				})
+			}
+		}
+
+	}
+}
+
 func BenchmarkUltrapoolV1Workerpool(b *testing.B) {
 	for _, info := range workLoads {
 
@@ -0,0 +1,125 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const cgroupRoot = "sys/fs/cgroup"
+
+// cgroupCPUQuota returns the CPU quota (in CPUs) of the cgroup the process
+// runs in, read from fsys rooted at "/". Both cgroup v2 (cpu.max) and v1
+// (cpu.cfs_quota_us / cpu.cfs_period_us) are supported; limits of ancestor
+// cgroups apply as well, so the smallest quota on the path wins. ok is false
+// without a quota, e.g. on systems without cgroups.
+func cgroupCPUQuota(fsys fs.FS) (quota float64, ok bool) {
+	v2Path, v1Path := "/", "/"
+	if data, err := fs.ReadFile(fsys, "proc/self/cgroup"); err == nil {
+		v2Path, v1Path = parseProcCgroup(data)
+	}
+
+	// Prefer v2; in hybrid setups the cpu controller may still be on v1.
+	if quota, ok = walkCgroup(fsys, cgroupRoot, v2Path, readCPUMax); ok {
+		return quota, true
+	}
+	for _, dir := range []string{"cpu", "cpu,cpuacct", "cpuacct,cpu"} {
+		if quota, ok = walkCgroup(fsys, path.Join(cgroupRoot, dir), v1Path, readCFSQuota); ok {
+			return quota, true
+		}
+	}
+
+	return 0, false
+}
+
+// parseProcCgroup returns the v2 cgroup path and the path in the v1 cpu
+// hierarchy from the contents of /proc/self/cgroup. Missing entries
+// default to "/".
+func parseProcCgroup(data []byte) (v2Path, v1Path string) {
+	v2Path, v1Path = "/", "/"
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			v2Path = fields[2]
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller == "cpu" {
+				v1Path = fields[2]
+			}
+		}
+	}
+
+	return v2Path, v1Path
+}
+
+// walkCgroup reads the quota of the cgroup at root+cgroupPath and of all its
+// ancestors and returns the smallest one
+func walkCgroup(fsys fs.FS, root, cgroupPath string, read func(fsys fs.FS, dir string) (float64, bool)) (quota float64, ok bool) {
+	p := path.Clean("/" + cgroupPath)
+	for {
+		if q, found := read(fsys, path.Join(root, p)); found && (!ok || q < quota) {
+			quota, ok = q, true
+		}
+		if p == "/" {
+			return quota, ok
+		}
+		p = path.Dir(p)
+	}
+}
+
+// readCPUMax parses a cgroup v2 cpu.max file ("$MAX $PERIOD", or "max" for
+// no limit)
+func readCPUMax(fsys fs.FS, dir string) (float64, bool) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0, false
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+
+	return cpuQuota(fields[0], fields[1])
+}
+
+// readCFSQuota parses the cgroup v1 cpu.cfs_quota_us (-1 for no limit) and
+// cpu.cfs_period_us files
+func readCFSQuota(fsys fs.FS, dir string) (float64, bool) {
+	quota, err := fs.ReadFile(fsys, path.Join(dir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	period, err := fs.ReadFile(fsys, path.Join(dir, "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+
+	return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func cpuQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+
+	return float64(q) / float64(p), true
+}
@@ -0,0 +1,61 @@
+package ultrapool
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestCgroupCPUQuota(t *testing.T) {
+	file := func(data string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(data)}
+	}
+
+	tests := []struct {
+		name      string
+		fsys      fstest.MapFS
+		wantQuota float64
+		wantOk    bool
+	}{
+		{"no cgroups", fstest.MapFS{}, 0, false},
+		{"v2 unlimited", fstest.MapFS{
+			"proc/self/cgroup":      file("0::/\n"),
+			"sys/fs/cgroup/cpu.max": file("max 100000\n"),
+		}, 0, false},
+		{"v2 root", fstest.MapFS{
+			"proc/self/cgroup":      file("0::/\n"),
+			"sys/fs/cgroup/cpu.max": file("250000 100000\n"),
+		}, 2.5, true},
+		{"v2 nested, ancestor is stricter", fstest.MapFS{
+			"proc/self/cgroup":                        file("0::/kubepods/pod1/ctr\n"),
+			"sys/fs/cgroup/kubepods/pod1/ctr/cpu.max": file("400000 100000\n"),
+			"sys/fs/cgroup/kubepods/pod1/cpu.max":     file("150000 100000\n"),
+			"sys/fs/cgroup/kubepods/cpu.max":          file("max 100000\n"),
+		}, 1.5, true},
+		{"v2 namespaced, no proc entry", fstest.MapFS{
+			"sys/fs/cgroup/cpu.max": file("50000 100000\n"),
+		}, 0.5, true},
+		{"v1", fstest.MapFS{
+			"proc/self/cgroup": file("12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n"),
+			"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  file("300000\n"),
+			"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_period_us": file("100000\n"),
+		}, 3, true},
+		{"v1 unlimited", fstest.MapFS{
+			"proc/self/cgroup":                    file("4:cpu,cpuacct:/\n"),
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":  file("-1\n"),
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us": file("100000\n"),
+		}, 0, false},
+		{"malformed", fstest.MapFS{
+			"sys/fs/cgroup/cpu.max": file("lots\n"),
+		}, 0, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			quota, ok := cgroupCPUQuota(tt.fsys)
+			if quota != tt.wantQuota || ok != tt.wantOk {
+				t.Errorf("got (%v, %v), want (%v, %v)", quota, ok, tt.wantQuota, tt.wantOk)
+			}
+		})
+	}
+}
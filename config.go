@@ -0,0 +1,173 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds the worker limits that can be changed while the pool runs
+// (see Reconfigure).
+type Config struct {
+	MaxWorkers         int // total workers across all shards; 0 means no limit
+	ShardMinWorkers    int // per-shard floor of workers kept alive when idle
+	ShardMaxWorkers    int // per-shard cap of workers
+	IdleWorkerLifetime time.Duration
+}
+
+// poolLimits is the immutable snapshot of Config that running workers and
+// dispatchers read. Reconfigure swaps the whole snapshot at once.
+type poolLimits struct {
+	maxWorkers         int64
+	shardMinWorkers    int64
+	shardMaxWorkers    int64
+	idleWorkerLifetime time.Duration
+}
+
+func (cfg Config) limits() *poolLimits {
+	return &poolLimits{
+		maxWorkers:         int64(cfg.MaxWorkers),
+		shardMinWorkers:    int64(cfg.ShardMinWorkers),
+		shardMaxWorkers:    int64(cfg.ShardMaxWorkers),
+		idleWorkerLifetime: cfg.IdleWorkerLifetime,
+	}
+}
+
+// validate checks the limits on their own and against the number of shards.
+func (cfg Config) validate(numShards int) error {
+	if cfg.MaxWorkers < 0 {
+		return fmt.Errorf("ultrapool: max workers must be >= 0, got %d", cfg.MaxWorkers)
+	}
+	if cfg.ShardMinWorkers < 1 {
+		return fmt.Errorf("ultrapool: shard min workers must be >= 1, got %d", cfg.ShardMinWorkers)
+	}
+	if cfg.ShardMaxWorkers < 1 {
+		return fmt.Errorf("ultrapool: shard max workers must be >= 1, got %d", cfg.ShardMaxWorkers)
+	}
+	// The floor is always at least one worker, so a zero lifetime would
+	// retire every other worker as soon as its queue runs dry.
+	if cfg.IdleWorkerLifetime <= 0 {
+		return fmt.Errorf("ultrapool: idle worker lifetime must be > 0, got %v", cfg.IdleWorkerLifetime)
+	}
+	if cfg.ShardMinWorkers > cfg.ShardMaxWorkers {
+		return fmt.Errorf("ultrapool: shard min workers (%d) exceed shard max workers (%d)",
+			cfg.ShardMinWorkers, cfg.ShardMaxWorkers)
+	}
+	if cfg.MaxWorkers > 0 && cfg.ShardMinWorkers*numShards > cfg.MaxWorkers {
+		return fmt.Errorf("ultrapool: %d shards with %d min workers each need %d workers, but max workers is %d",
+			numShards, cfg.ShardMinWorkers, cfg.ShardMinWorkers*numShards, cfg.MaxWorkers)
+	}
+
+	return nil
+}
+
+// Returns the current worker limits
+func (wp *WorkerPool[T]) Config() Config {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	return wp.config()
+}
+
+// config returns the configured limits; wp.mutex must be held
+func (wp *WorkerPool[T]) config() Config {
+	return Config{
+		MaxWorkers:         wp.maxWorkers,
+		ShardMinWorkers:    wp.shardMinWorkers,
+		ShardMaxWorkers:    wp.shardMaxWorkers,
+		IdleWorkerLifetime: wp.idleWorkerLifetime,
+	}
+}
+
+// Atomically replaces the worker limits of a (possibly running) pool. The Set*
+// methods only take effect at Start; this is the way to change a running pool,
+// including one created by NewWorkerPoolWithOptions.
+//
+// Raising the floor spawns the missing workers right away. Lowering a cap lets
+// surplus workers retire once they finish their current task; lowering the
+// floor lets surplus workers retire after their next idle timeout. A new idle
+// lifetime applies from each worker's next idle period on.
+func (wp *WorkerPool[T]) Reconfigure(cfg Config) error {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	if err := cfg.validate(wp.numShards); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&wp.stopped) != 0 {
+		return ErrPoolStopped
+	}
+
+	wp.applyConfig(cfg)
+
+	return nil
+}
+
+// applyConfig puts validated limits into effect; wp.mutex must be held
+func (wp *WorkerPool[T]) applyConfig(cfg Config) {
+	wp.maxWorkers = cfg.MaxWorkers
+	wp.shardMinWorkers = cfg.ShardMinWorkers
+	wp.shardMaxWorkers = cfg.ShardMaxWorkers
+	wp.idleWorkerLifetime = cfg.IdleWorkerLifetime
+
+	if !wp.started {
+		return
+	}
+
+	limits := cfg.limits()
+	wp.limits.Store(limits)
+	for _, shard := range wp.shards.Load().shards {
+		shard.spawnToFloor(limits.shardMinWorkers)
+	}
+}
+
+// spawnToFloor spawns workers until the shard has at least floor workers
+func (shard *poolShard[T]) spawnToFloor(floor int64) {
+	for {
+		cur := atomic.LoadInt64(&shard.workers)
+		if cur >= floor {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&shard.workers, cur, cur+1) {
+			atomic.AddUint64(&shard.wp.spawnedWorkers, 1)
+			atomic.AddUint64(&shard.spawned, 1)
+			go shard.workerLoop()
+		}
+	}
+}
+
+// tryRetire takes the calling worker out of the shard's count if the shard
+// exceeds its cap or the pool its global cap, but never below the floor.
+// The global check is best effort: concurrent retirements may undershoot
+// the global cap, bounded by the floors.
+func (shard *poolShard[T]) tryRetire(limits *poolLimits) bool {
+	for {
+		workers := atomic.LoadInt64(&shard.workers)
+		if workers <= limits.shardMinWorkers {
+			return false
+		}
+		if workers <= limits.shardMaxWorkers &&
+			(limits.maxWorkers == 0 || atomic.LoadUint64(&shard.wp.spawnedWorkers) <= uint64(limits.maxWorkers)) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&shard.workers, workers, workers-1) {
+			atomic.AddUint64(&shard.retired, 1)
+			return true
+		}
+	}
+}
+
+// retireOnReconfigure reports whether the calling worker should retire
+// because the limits changed since it last looked at them (*seen).
+func (shard *poolShard[T]) retireOnReconfigure(seen **poolLimits) bool {
+	limits := shard.wp.limits.Load()
+	if limits == *seen {
+		return false
+	}
+	*seen = limits
+
+	return shard.tryRetire(limits)
+}
@@ -0,0 +1,225 @@
+package ultrapool
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconfigureRaisesFloor(t *testing.T) {
+	wp := NewWorkerPool(func(task int) {})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(8)
+	wp.Start()
+	defer wp.Stop()
+
+	if got := wp.GetSpawnedWorkers(); got != 2 {
+		t.Fatalf("spawned workers after Start: got %d, want 2", got)
+	}
+
+	cfg := wp.Config()
+	cfg.ShardMinWorkers = 5
+	if err := wp.Reconfigure(cfg); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	// New floor workers are spawned synchronously.
+	if got := wp.GetSpawnedWorkers(); got != 10 {
+		t.Errorf("spawned workers after raising the floor: got %d, want 10", got)
+	}
+	if got := wp.Config(); got != cfg {
+		t.Errorf("Config after Reconfigure: got %+v, want %+v", got, cfg)
+	}
+
+	// The raised floor holds: nobody retires.
+	time.Sleep(3 * defaultIdleWorkerLifetime / 2)
+	if got := wp.GetSpawnedWorkers(); got != 10 {
+		t.Errorf("spawned workers after idling: got %d, want 10", got)
+	}
+}
+
+func TestReconfigureLowersCap(t *testing.T) {
+	const queueSize = 16
+	const shardMax = 6
+
+	var mu sync.Mutex
+	reasons := map[WorkerExitReason]int{}
+
+	release := make(chan struct{})
+	var running int32
+	wp := NewWorkerPool(func(task int) {
+		atomic.AddInt32(&running, 1)
+		<-release
+	})
+	wp.SetNumShards(1)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(shardMax)
+	wp.SetQueueSize(queueSize)
+	wp.SetIdleWorkerLifetime(time.Hour)
+	wp.SetHooks(&Hooks[int]{
+		OnWorkerExit: func(shard int, reason WorkerExitReason) {
+			mu.Lock()
+			reasons[reason]++
+			mu.Unlock()
+		},
+	})
+	wp.Start()
+	defer wp.Stop()
+
+	for i := 0; i < shardMax; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask(%d): %v", i, err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&running) < shardMax {
+		time.Sleep(time.Millisecond)
+	}
+	if got := wp.GetSpawnedWorkers(); got != shardMax {
+		t.Fatalf("spawned workers while saturated: got %d, want %d", got, shardMax)
+	}
+
+	if err := wp.Reconfigure(Config{
+		ShardMinWorkers:    1,
+		ShardMaxWorkers:    2,
+		IdleWorkerLifetime: time.Hour,
+	}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	// The cap is not enforced by interrupting running tasks...
+	if got := wp.GetSpawnedWorkers(); got != shardMax {
+		t.Errorf("spawned workers right after lowering the cap: got %d, want %d", got, shardMax)
+	}
+
+	// ...but surplus workers retire as soon as their task is done, long
+	// before the idle lifetime of an hour.
+	close(release)
+	deadline = time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && wp.GetSpawnedWorkers() > 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if got := wp.GetSpawnedWorkers(); got != 2 {
+		t.Errorf("spawned workers after lowering the cap: got %d, want 2", got)
+	}
+
+	mu.Lock()
+	if reasons[WorkerExitExcess] != shardMax-2 {
+		t.Errorf("OnWorkerExit(excess) calls: got %d, want %d", reasons[WorkerExitExcess], shardMax-2)
+	}
+	mu.Unlock()
+
+	// The new cap also applies to spawning.
+	for i := 0; i < 100; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+		if got := wp.GetSpawnedWorkers(); got > 2 {
+			t.Fatalf("spawned workers exceeded the lowered cap: got %d, want <= 2", got)
+		}
+	}
+}
+
+func TestReconfigureValidation(t *testing.T) {
+	wp := NewWorkerPool(func(task int) {})
+	wp.SetNumShards(4)
+
+	valid := Config{MaxWorkers: 8, ShardMinWorkers: 2, ShardMaxWorkers: 4, IdleWorkerLifetime: time.Second}
+
+	for _, tt := range []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string
+	}{
+		{"negative max", func(cfg *Config) { cfg.MaxWorkers = -1 }, "max workers must be >= 0"},
+		{"zero floor", func(cfg *Config) { cfg.ShardMinWorkers = 0 }, "shard min workers must be >= 1"},
+		{"zero cap", func(cfg *Config) { cfg.ShardMaxWorkers = 0 }, "shard max workers must be >= 1"},
+		{"zero lifetime", func(cfg *Config) { cfg.IdleWorkerLifetime = 0 }, "idle worker lifetime must be > 0"},
+		{"floor above cap", func(cfg *Config) { cfg.ShardMinWorkers = 5 }, "shard min workers (5) exceed shard max workers (4)"},
+		{"floors above global cap", func(cfg *Config) { cfg.ShardMinWorkers = 3 }, "4 shards with 3 min workers each need 12 workers"},
+	} {
+		cfg := valid
+		tt.modify(&cfg)
+		err := wp.Reconfigure(cfg)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got %v, want error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Before Start, Reconfigure acts like the setters.
+	if err := wp.Reconfigure(valid); err != nil {
+		t.Fatalf("Reconfigure(valid): %v", err)
+	}
+	wp.Start()
+	if got := wp.GetSpawnedWorkers(); got != 8 {
+		t.Errorf("spawned workers after Start: got %d, want 8", got)
+	}
+
+	wp.StopAndWait()
+	if err := wp.Reconfigure(valid); err != ErrPoolStopped {
+		t.Errorf("Reconfigure after stop: got %v, want ErrPoolStopped", err)
+	}
+}
+
+func TestReconfigureUnderLoad(t *testing.T) {
+	var completed int64
+
+	wp := NewWorkerPool(func(task int) {
+		time.Sleep(10 * time.Microsecond)
+		atomic.AddInt64(&completed, 1)
+	})
+	wp.SetNumShards(4)
+	wp.SetIdleWorkerLifetime(time.Millisecond)
+	wp.Start()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = wp.Reconfigure(Config{
+				ShardMinWorkers:    1 + i%3,
+				ShardMaxWorkers:    3 + i%5,
+				IdleWorkerLifetime: time.Duration(1+i%4) * time.Millisecond,
+			})
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	const producers = 8
+	const tasksPerProducer = 500
+	var pwg sync.WaitGroup
+	pwg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer pwg.Done()
+			for i := 0; i < tasksPerProducer; i++ {
+				if err := wp.AddTaskWithBlocking(i); err != nil {
+					t.Errorf("AddTaskWithBlocking: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	pwg.Wait()
+	close(stop)
+	wg.Wait()
+
+	wp.StopAndWait()
+
+	if got := atomic.LoadInt64(&completed); got != producers*tasksPerProducer {
+		t.Errorf("completed tasks: got %d, want %d", got, producers*tasksPerProducer)
+	}
+	if got := wp.GetSpawnedWorkers(); got != 0 {
+		t.Errorf("spawned workers after StopAndWait: got %d, want 0", got)
+	}
+}
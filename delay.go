@@ -0,0 +1,279 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DelayOverload selects what happens to a due delayed task whose shard queue
+// is full.
+type DelayOverload int
+
+const (
+	// Drop the task (reported to DelayPolicy.OnDrop with ErrPoolOverload).
+	DelayOverloadDrop DelayOverload = iota
+	// Try again after DelayPolicy.RetryInterval.
+	DelayOverloadRetry
+	// Block the timer until the task is accepted; other tasks of the same
+	// timer heap that are due meanwhile wait as well.
+	DelayOverloadBlock
+)
+
+const defaultDelayRetryInterval = 10 * time.Millisecond
+
+// DelayPolicy configures tasks added with AddTaskAfter and AddTaskAt.
+type DelayPolicy[T any] struct {
+	// What happens to a due task whose shard queue is full.
+	Overload DelayOverload
+
+	// Delay between attempts with DelayOverloadRetry; defaults to 10ms.
+	RetryInterval time.Duration
+
+	// Whether tasks still pending on Stop are dispatched right away (and
+	// thus executed before StopAndWait returns) instead of being dropped.
+	// Stop then waits for room in the queues if the pool is saturated.
+	FireOnStop bool
+
+	// Called with every delayed task that is dropped, with ErrPoolOverload
+	// or ErrPoolStopped. May be nil.
+	OnDrop func(task T, err error)
+}
+
+// DelayedTask is the handle of a task added with AddTaskAfter or AddTaskAt.
+type DelayedTask[T any] struct {
+	task  T
+	at    time.Time
+	index int // position in the timer heap; -1 once fired or cancelled
+	timer *timerHeap[T]
+}
+
+// Returns the time the task becomes due; with DelayOverloadRetry, that is
+// the time of the next attempt
+func (dt *DelayedTask[T]) At() time.Time {
+	dt.timer.mutex.Lock()
+	defer dt.timer.mutex.Unlock()
+
+	return dt.at
+}
+
+// Cancels the task. Returns false if it is already due (dispatched or
+// dropped) or was cancelled before.
+func (dt *DelayedTask[T]) Cancel() bool {
+	th := dt.timer
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	if dt.index < 0 {
+		return false
+	}
+	heap.Remove(&th.tasks, dt.index)
+
+	return true
+}
+
+// delayedTasks is a min-heap of delayed tasks ordered by due time
+type delayedTasks[T any] []*DelayedTask[T]
+
+func (h delayedTasks[T]) Len() int           { return len(h) }
+func (h delayedTasks[T]) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h delayedTasks[T]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *delayedTasks[T]) Push(x any) {
+	dt := x.(*DelayedTask[T])
+	dt.index = len(*h)
+	*h = append(*h, dt)
+}
+
+func (h *delayedTasks[T]) Pop() any {
+	old := *h
+	n := len(old)
+	dt := old[n-1]
+	old[n-1] = nil
+	dt.index = -1
+	*h = old[:n-1]
+
+	return dt
+}
+
+// timerHeap is one of the pool's timer shards: a heap of delayed tasks and a
+// single timer armed for the earliest one. Tasks are spread over the shards
+// at random to keep the mutex uncontended.
+type timerHeap[T any] struct {
+	wp      *WorkerPool[T]
+	mutex   sync.Mutex
+	tasks   delayedTasks[T]
+	timer   *time.Timer
+	stopped bool
+}
+
+func newTimerHeap[T any](wp *WorkerPool[T]) *timerHeap[T] {
+	th := &timerHeap[T]{wp: wp}
+	th.timer = time.AfterFunc(time.Hour, th.fire)
+	th.timer.Stop()
+
+	return th
+}
+
+// Sets the policy for delayed tasks (see AddTaskAfter). Must be called
+// before Start.
+func (wp *WorkerPool[T]) SetDelayPolicy(policy DelayPolicy[T]) {
+	wp.checkMutable("SetDelayPolicy")
+	wp.delayPolicy = policy
+}
+
+// Adds a task that is dispatched once delay has elapsed. No goroutine waits
+// for the task meanwhile; it sits in a timer heap that feeds the regular
+// dispatch path when due. Delayed tasks that are not due yet do not count as
+// in flight for Wait.
+func (wp *WorkerPool[T]) AddTaskAfter(delay time.Duration, task T) (*DelayedTask[T], error) {
+	return wp.AddTaskAt(time.Now().Add(delay), task)
+}
+
+// Adds a task that is dispatched at the given time (see AddTaskAfter)
+func (wp *WorkerPool[T]) AddTaskAt(at time.Time, task T) (*DelayedTask[T], error) {
+	if !wp.started {
+		return nil, errors.New("worker pool must be started first")
+	}
+
+	th := wp.timers[randInt()%len(wp.timers)]
+	dt := &DelayedTask[T]{task: task, timer: th}
+	if err := th.push(dt, at); err != nil {
+		return nil, err
+	}
+
+	return dt, nil
+}
+
+// push adds a task due at the given time and re-arms the timer if it is the
+// new earliest one
+func (th *timerHeap[T]) push(dt *DelayedTask[T], at time.Time) error {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	if th.stopped {
+		return ErrPoolStopped
+	}
+	dt.at = at
+	heap.Push(&th.tasks, dt)
+	if dt.index == 0 {
+		th.timer.Reset(time.Until(dt.at))
+	}
+
+	return nil
+}
+
+// fire runs on the timer's goroutine: it dispatches all due tasks and
+// re-arms the timer for the next one
+func (th *timerHeap[T]) fire() {
+	var due []*DelayedTask[T]
+
+	th.mutex.Lock()
+	now := time.Now()
+	for len(th.tasks) > 0 && !th.tasks[0].at.After(now) {
+		due = append(due, heap.Pop(&th.tasks).(*DelayedTask[T]))
+	}
+	if len(th.tasks) > 0 && !th.stopped {
+		th.timer.Reset(th.tasks[0].at.Sub(now))
+	}
+	th.mutex.Unlock()
+
+	for _, dt := range due {
+		th.dispatch(dt)
+	}
+}
+
+// dispatch hands a due task to the pool according to the overload policy
+func (th *timerHeap[T]) dispatch(dt *DelayedTask[T]) {
+	wp := th.wp
+	policy := &wp.delayPolicy
+
+	var err error
+	if policy.Overload == DelayOverloadBlock {
+		err = wp.AddTaskWithBlocking(dt.task)
+	} else {
+		err = wp.addTask(laneNormal, dt.task, false)
+	}
+
+	if err == ErrPoolOverload && policy.Overload == DelayOverloadRetry {
+		retryInterval := policy.RetryInterval
+		if retryInterval <= 0 {
+			retryInterval = defaultDelayRetryInterval
+		}
+		if err = th.push(dt, time.Now().Add(retryInterval)); err == nil {
+			return
+		}
+	}
+
+	if err == ErrPoolOverload {
+		// Retries don't count, a dropped task does.
+		shards := wp.shards.Load().shards
+		atomic.AddUint64(&wp.shardOf(shards, dt.task).rejected, 1)
+	}
+	if err != nil && policy.OnDrop != nil {
+		policy.OnDrop(dt.task, err)
+	}
+}
+
+// stop takes the pending tasks out of the heap and disarms the timer; no
+// tasks are accepted afterwards
+func (th *timerHeap[T]) stop() []*DelayedTask[T] {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	th.stopped = true
+	th.timer.Stop()
+
+	pending := make([]*DelayedTask[T], 0, len(th.tasks))
+	for len(th.tasks) > 0 {
+		pending = append(pending, heap.Pop(&th.tasks).(*DelayedTask[T]))
+	}
+
+	return pending
+}
+
+// stopTimers runs before the pool stops: pending delayed tasks are either
+// dispatched (waiting for queue capacity) or dropped
+func (wp *WorkerPool[T]) stopTimers() {
+	policy := &wp.delayPolicy
+
+	for _, th := range wp.timers {
+		for _, dt := range th.stop() {
+			err := ErrPoolStopped
+			if policy.FireOnStop {
+				err = wp.AddTaskWithBlockingContext(context.Background(), dt.task)
+			}
+			if err != nil && policy.OnDrop != nil {
+				policy.OnDrop(dt.task, err)
+			}
+		}
+	}
+}
+
+// delayedLen returns the number of delayed tasks that are not due yet
+func (wp *WorkerPool[T]) delayedLen() int {
+	// Start publishes the shard table after creating the timers.
+	if wp.shards.Load() == nil {
+		return 0
+	}
+
+	n := 0
+	for _, th := range wp.timers {
+		th.mutex.Lock()
+		n += len(th.tasks)
+		th.mutex.Unlock()
+	}
+
+	return n
+}
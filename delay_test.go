@@ -0,0 +1,254 @@
+package ultrapool
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAddTaskAfter(t *testing.T) {
+	var mu sync.Mutex
+	var order []int
+	ran := make(chan struct{}, 3)
+
+	wp := NewWorkerPool(func(task int) {
+		mu.Lock()
+		order = append(order, task)
+		mu.Unlock()
+		ran <- struct{}{}
+	})
+	wp.Start()
+	defer wp.Stop()
+
+	start := time.Now()
+	for _, delay := range []int{30, 10, 20} {
+		if _, err := wp.AddTaskAfter(time.Duration(delay)*time.Millisecond, delay); err != nil {
+			t.Fatalf("AddTaskAfter(%dms): %v", delay, err)
+		}
+	}
+	if got := wp.Stats().Delayed; got != 3 {
+		t.Errorf("Stats.Delayed: got %d, want 3", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ran:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of 3 delayed tasks ran", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("all delayed tasks ran after %v, want >= 30ms", elapsed)
+	}
+
+	mu.Lock()
+	if want := []int{10, 20, 30}; !reflect.DeepEqual(order, want) {
+		t.Errorf("execution order: got %v, want %v", order, want)
+	}
+	mu.Unlock()
+
+	if got := wp.Stats().Delayed; got != 0 {
+		t.Errorf("Stats.Delayed after firing: got %d, want 0", got)
+	}
+}
+
+func TestDelayedTaskCancel(t *testing.T) {
+	ran := make(chan int, 2)
+	wp := NewWorkerPool(func(task int) { ran <- task })
+	wp.Start()
+	defer wp.Stop()
+
+	cancelled, err := wp.AddTaskAfter(20*time.Millisecond, 1)
+	if err != nil {
+		t.Fatalf("AddTaskAfter: %v", err)
+	}
+	fired, err := wp.AddTaskAt(time.Now().Add(10*time.Millisecond), 2)
+	if err != nil {
+		t.Fatalf("AddTaskAt: %v", err)
+	}
+
+	if !cancelled.Cancel() {
+		t.Error("Cancel of a pending task: got false, want true")
+	}
+	if cancelled.Cancel() {
+		t.Error("second Cancel: got true, want false")
+	}
+
+	if got := <-ran; got != 2 {
+		t.Errorf("ran task %d, want 2", got)
+	}
+	if fired.Cancel() {
+		t.Error("Cancel of a fired task: got true, want false")
+	}
+
+	select {
+	case task := <-ran:
+		t.Errorf("cancelled task %d ran", task)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDelayedTasksOnStop(t *testing.T) {
+	for _, fireOnStop := range []bool{false, true} {
+		var mu sync.Mutex
+		var ran, dropped []int
+
+		wp := NewWorkerPool(func(task int) {
+			mu.Lock()
+			ran = append(ran, task)
+			mu.Unlock()
+		})
+		wp.SetDelayPolicy(DelayPolicy[int]{
+			FireOnStop: fireOnStop,
+			OnDrop: func(task int, err error) {
+				if err != ErrPoolStopped {
+					t.Errorf("OnDrop(%d): got %v, want ErrPoolStopped", task, err)
+				}
+				mu.Lock()
+				dropped = append(dropped, task)
+				mu.Unlock()
+			},
+		})
+		wp.Start()
+
+		if _, err := wp.AddTaskAfter(time.Hour, 1); err != nil {
+			t.Fatalf("AddTaskAfter: %v", err)
+		}
+		wp.StopAndWait()
+
+		if _, err := wp.AddTaskAfter(time.Millisecond, 2); err != ErrPoolStopped {
+			t.Errorf("AddTaskAfter after Stop: got %v, want ErrPoolStopped", err)
+		}
+
+		mu.Lock()
+		if fireOnStop && (len(ran) != 1 || len(dropped) != 0) {
+			t.Errorf("FireOnStop: ran %v, dropped %v; want the task to run", ran, dropped)
+		}
+		if !fireOnStop && (len(ran) != 0 || len(dropped) != 1) {
+			t.Errorf("drop on Stop: ran %v, dropped %v; want the task to be dropped", ran, dropped)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestDelayedTaskOverload(t *testing.T) {
+	const queueSize = 16
+
+	for _, overload := range []DelayOverload{DelayOverloadDrop, DelayOverloadRetry, DelayOverloadBlock} {
+		started := make(chan int, queueSize+2)
+		dropped := make(chan error, 1)
+
+		wp, _, releaseAll := engageBlockedPoolWith(t, 1, queueSize, time.Hour, func(wp *WorkerPool[int]) {
+			wp.SetHooks(&Hooks[int]{
+				OnTaskStart: func(shard int, task int) { started <- task },
+			})
+			wp.SetDelayPolicy(DelayPolicy[int]{
+				Overload:      overload,
+				RetryInterval: time.Millisecond,
+				OnDrop: func(task int, err error) {
+					dropped <- err
+				},
+			})
+		})
+		<-started
+
+		for i := 0; i < queueSize; i++ {
+			if err := wp.AddTask(i); err != nil {
+				t.Fatalf("AddTask buffer fill %d: %v", i, err)
+			}
+		}
+
+		if _, err := wp.AddTaskAfter(time.Millisecond, 100); err != nil {
+			t.Fatalf("AddTaskAfter: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		releaseAll()
+
+		if overload == DelayOverloadDrop {
+			if err := <-dropped; err != ErrPoolOverload {
+				t.Errorf("drop policy: OnDrop got %v, want ErrPoolOverload", err)
+			}
+			wp.StopAndWait()
+			continue
+		}
+
+		// Retry and block deliver the task once there is room again.
+		deadline := time.After(2 * time.Second)
+		for task := -1; task != 100; {
+			select {
+			case task = <-started:
+			case <-deadline:
+				t.Fatalf("policy %d: delayed task never ran", overload)
+			}
+		}
+		wp.StopAndWait()
+
+		select {
+		case err := <-dropped:
+			t.Errorf("policy %d: task dropped with %v", overload, err)
+		default:
+		}
+	}
+}
+
+func TestDelayedTasksFireOnStopSaturated(t *testing.T) {
+	const queueSize = 16
+
+	ran := make(chan int, queueSize+2)
+	wp, _, releaseAll := engageBlockedPoolWith(t, 1, queueSize, time.Hour, func(wp *WorkerPool[int]) {
+		wp.SetHooks(&Hooks[int]{
+			OnTaskStart: func(shard int, task int) { ran <- task },
+		})
+		wp.SetDelayPolicy(DelayPolicy[int]{FireOnStop: true})
+	})
+	defer releaseAll()
+	<-ran
+
+	for i := 0; i < queueSize; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask buffer fill %d: %v", i, err)
+		}
+	}
+	if _, err := wp.AddTaskAfter(time.Hour, 100); err != nil {
+		t.Fatalf("AddTaskAfter: %v", err)
+	}
+
+	// Stop waits for room for the delayed task, but must not hold the pool's
+	// lock meanwhile.
+	stopped := make(chan struct{})
+	go func() {
+		wp.Stop()
+		close(stopped)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	configured := make(chan struct{})
+	go func() {
+		_ = wp.Config()
+		close(configured)
+	}()
+	select {
+	case <-configured:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Config blocked while Stop waited for room")
+	}
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the delayed task was dispatched")
+	default:
+	}
+
+	releaseAll()
+	<-stopped
+	wp.StopAndWait()
+
+	close(ran)
+	fired := false
+	for task := range ran {
+		fired = fired || task == 100
+	}
+	if !fired {
+		t.Error("the delayed task did not run")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Future is the pending result of a task submitted via ResultPool.Submit.
+//
+// Futures are pooled: call Release once the result has been read to hand the
+// future back for reuse. A released future must not be used anymore.
+type Future[R any] struct {
+	pool   *sync.Pool
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	done   chan struct{}
+	state  uint32
+	result R
+	err    error
+}
+
+// Blocks until the task has completed
+func (f *Future[R]) Wait() {
+	f.wg.Wait()
+}
+
+// Blocks until the task has completed or ctx is done. Returns ctx.Err() if
+// ctx is done first.
+func (f *Future[R]) WaitContext(ctx context.Context) error {
+	if atomic.LoadUint32(&f.state) != 0 {
+		return nil
+	}
+
+	select {
+	case <-f.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns a channel that is closed once the task has completed. The channel
+// is created lazily, so futures that are only waited on never allocate one.
+func (f *Future[R]) Done() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.done == nil {
+		f.done = make(chan struct{})
+		if atomic.LoadUint32(&f.state) != 0 {
+			close(f.done)
+		}
+	}
+
+	return f.done
+}
+
+// Blocks until the task has completed and returns its result and error
+func (f *Future[R]) Result() (R, error) {
+	f.wg.Wait()
+	return f.result, f.err
+}
+
+// Hands the future back for reuse. Release is a no-op on futures that have
+// not completed yet, as the worker still owns them.
+func (f *Future[R]) Release() {
+	if f.pool == nil || atomic.LoadUint32(&f.state) == 0 {
+		return
+	}
+
+	var zero R
+	f.result = zero
+	f.err = nil
+	f.done = nil
+	atomic.StoreUint32(&f.state, 0)
+	f.pool.Put(f)
+}
+
+func (f *Future[R]) complete(result R, err error) {
+	f.result = result
+	f.err = err
+
+	f.mu.Lock()
+	atomic.StoreUint32(&f.state, 1)
+	if f.done != nil {
+		close(f.done)
+	}
+	f.mu.Unlock()
+
+	f.wg.Done()
+}
+
+// discard hands back a future whose task was never submitted
+func (f *Future[R]) discard() {
+	f.wg.Done()
+	f.pool.Put(f)
+}
+
+// getFuture returns a pending future from the pool
+func getFuture[R any](pool *sync.Pool) *Future[R] {
+	f, _ := pool.Get().(*Future[R])
+	if f == nil {
+		f = &Future[R]{pool: pool}
+	}
+	f.wg.Add(1)
+
+	return f
+}
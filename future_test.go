@@ -0,0 +1,129 @@
+package ultrapool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFutureResult(t *testing.T) {
+	errNegative := errors.New("negative")
+	rp := NewResultPool(func(task int) (int, error) {
+		if task < 0 {
+			return 0, errNegative
+		}
+		return task * task, nil
+	})
+	rp.SetNumShards(2)
+	rp.Start()
+	defer rp.Stop()
+
+	futures := make([]*Future[int], 0, 100)
+	for i := -10; i < 90; i++ {
+		f, err := rp.SubmitWithBlocking(i)
+		if err != nil {
+			t.Fatalf("SubmitWithBlocking(%d): %v", i, err)
+		}
+		futures = append(futures, f)
+	}
+
+	for n, f := range futures {
+		i := n - 10
+		result, err := f.Result()
+		if i < 0 {
+			if err != errNegative {
+				t.Errorf("task %d: got err %v, want errNegative", i, err)
+			}
+		} else if err != nil || result != i*i {
+			t.Errorf("task %d: got (%d, %v), want (%d, nil)", i, result, err, i*i)
+		}
+		f.Release()
+	}
+}
+
+func TestFutureDoneAndWaitContext(t *testing.T) {
+	release := make(chan struct{})
+	rp := NewResultPool(func(task int) (int, error) {
+		<-release
+		return task, nil
+	})
+	rp.SetNumShards(1)
+	rp.Start()
+	defer rp.Stop()
+
+	f, err := rp.Submit(42)
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := f.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitContext on pending future: got %v, want context.DeadlineExceeded", err)
+	}
+
+	// Release on a pending future must not recycle it.
+	f.Release()
+
+	select {
+	case <-f.Done():
+		t.Fatal("Done closed before the task completed")
+	default:
+	}
+
+	close(release)
+
+	select {
+	case <-f.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done never closed after the task completed")
+	}
+	if err := f.WaitContext(context.Background()); err != nil {
+		t.Errorf("WaitContext on completed future: got %v, want nil", err)
+	}
+	f.Wait()
+	if result, err := f.Result(); result != 42 || err != nil {
+		t.Errorf("Result: got (%d, %v), want (42, nil)", result, err)
+	}
+	f.Release()
+}
+
+func TestFutureSubmitAfterStop(t *testing.T) {
+	rp := NewResultPool(func(task int) (int, error) {
+		return task, nil
+	})
+	rp.SetNumShards(1)
+	rp.Start()
+	rp.StopAndWait()
+
+	f, err := rp.Submit(1)
+	if err != ErrPoolStopped {
+		t.Fatalf("Submit after stop: got %v, want ErrPoolStopped", err)
+	}
+	if f != nil {
+		t.Error("Submit after stop returned a non-nil future")
+	}
+}
+
+func TestFutureAllocations(t *testing.T) {
+	rp := NewResultPool(func(task int) (int, error) {
+		return task, nil
+	})
+	rp.SetNumShards(1)
+	rp.SetShardMinWorkers(4)
+	rp.Start()
+	defer rp.Stop()
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		f, err := rp.SubmitWithBlocking(1)
+		if err != nil {
+			t.Fatalf("SubmitWithBlocking: %v", err)
+		}
+		f.Wait()
+		f.Release()
+	})
+	if allocs >= 1 {
+		t.Errorf("allocations per Submit/Wait/Release: got %.2f, want < 1", allocs)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"context"
+	"sync"
+)
+
+// Group is an errgroup-style subset of tasks submitted to a shared
+// WorkerPool. It tracks completion and the first error of its own tasks
+// only; the pool keeps running for everyone else.
+//
+// A task fails if its handler panics (with a panic handler set, see
+// SetPanicHandler; the group's error is then ErrTaskPanicked), if it cannot
+// be submitted, or if the rejection policy RejectDropOldest evicts it from
+// its queue (ErrPoolOverload). The first failure cancels the group's context.
+// Tasks of the group that are still queued at that point are skipped instead
+// of handled.
+type Group[T any] struct {
+	wp    *WorkerPool[T]
+	meta  *taskMeta // shared by the group's tasks
+	state taskGroup
+}
+
+// taskGroup is the state of a group its queued tasks refer to
+type taskGroup struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// Creates a new task group on the pool. The group's context derives from ctx;
+// canceling ctx skips the group's pending tasks just like a failure does.
+func (wp *WorkerPool[T]) NewGroup(ctx context.Context) *Group[T] {
+	g := &Group[T]{wp: wp}
+	g.state.ctx, g.state.cancel = context.WithCancel(ctx)
+	g.meta = &taskMeta{group: &g.state}
+
+	return g
+}
+
+// Returns the group's context, which is canceled on the first failure, when
+// the parent context is done or when Wait returns
+func (g *Group[T]) Context() context.Context {
+	return g.state.ctx
+}
+
+// Submits a task to the group, blocking while the pool is saturated. A task
+// that cannot be submitted (pool stopped, group canceled) counts as failed.
+func (g *Group[T]) Go(task T) {
+	if err := g.state.ctx.Err(); err != nil {
+		g.state.fail(err)
+		return
+	}
+
+	g.state.wg.Add(1)
+	qt := QueuedTask[T]{task: task, meta: g.meta}
+	err := g.wp.blockOnOverload(g.state.ctx, func() error {
+		return g.wp.addQueued(laneNormal, qt, false)
+	})
+	if err != nil {
+		g.state.done(err)
+	}
+}
+
+// Blocks until all tasks submitted via Go have completed or were skipped, and
+// returns the first error (if any)
+func (g *Group[T]) Wait() error {
+	return g.state.wait()
+}
+
+func (tg *taskGroup) wait() error {
+	tg.wg.Wait()
+	tg.cancel()
+
+	return tg.err
+}
+
+// skip reports whether the group was canceled before the task could run, and
+// accounts for the skipped task if so
+func (tg *taskGroup) skip() bool {
+	err := tg.ctx.Err()
+	if err == nil {
+		return false
+	}
+
+	tg.done(err)
+	return true
+}
+
+// finish accounts for a task that ran, given the recovered panic value of its
+// handler
+func (tg *taskGroup) finish(recovered any) {
+	var err error
+	if recovered != nil {
+		err = ErrTaskPanicked
+	}
+	tg.done(err)
+}
+
+func (tg *taskGroup) done(err error) {
+	if err != nil {
+		tg.fail(err)
+	}
+	tg.wg.Done()
+}
+
+func (tg *taskGroup) fail(err error) {
+	tg.errOnce.Do(func() {
+		tg.err = err
+		tg.cancel()
+	})
+}
+
+// ResultGroup is a Group of ResultPool tasks. Besides the failures of a
+// Group, a task fails if its handler returns an error.
+type ResultGroup[T, R any] struct {
+	group *Group[resultTask[T, R]]
+}
+
+// Creates a new task group on the pool. The group's context derives from ctx;
+// canceling ctx skips the group's pending tasks just like a failure does.
+func (rp *ResultPool[T, R]) NewGroup(ctx context.Context) *ResultGroup[T, R] {
+	return &ResultGroup[T, R]{group: rp.pool.NewGroup(ctx)}
+}
+
+// Returns the group's context, which is canceled on the first failure, when
+// the parent context is done or when Wait returns
+func (g *ResultGroup[T, R]) Context() context.Context {
+	return g.group.Context()
+}
+
+// Submits a task to the group, blocking while the pool is saturated. A task
+// that cannot be submitted (pool stopped, group canceled) counts as failed.
+func (g *ResultGroup[T, R]) Go(task T) {
+	g.group.Go(resultTask[T, R]{task: task, group: &g.group.state})
+}
+
+// Blocks until all tasks submitted via Go have completed or were skipped, and
+// returns the first error (if any)
+func (g *ResultGroup[T, R]) Wait() error {
+	return g.group.Wait()
+}
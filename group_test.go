@@ -0,0 +1,174 @@
+package ultrapool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGroupWaitsForItsTasks(t *testing.T) {
+	var handled int64
+
+	rp := NewResultPool(func(task int) (struct{}, error) {
+		atomic.AddInt64(&handled, 1)
+		return struct{}{}, nil
+	})
+	rp.SetNumShards(2)
+	rp.Start()
+	defer rp.Stop()
+
+	g1 := rp.NewGroup(context.Background())
+	g2 := rp.NewGroup(context.Background())
+	for i := 0; i < 100; i++ {
+		g1.Go(i)
+		g2.Go(i)
+	}
+
+	if err := g1.Wait(); err != nil {
+		t.Errorf("g1.Wait: got %v, want nil", err)
+	}
+	if err := g2.Wait(); err != nil {
+		t.Errorf("g2.Wait: got %v, want nil", err)
+	}
+	if got := atomic.LoadInt64(&handled); got != 200 {
+		t.Errorf("handled tasks: got %d, want 200", got)
+	}
+	if g1.Context().Err() == nil {
+		t.Error("group context not canceled after Wait")
+	}
+}
+
+func TestGroupFirstErrorCancelsPending(t *testing.T) {
+	errFirst := errors.New("first")
+	release := make(chan struct{})
+	var handled int64
+
+	rp := NewResultPool(func(task int) (struct{}, error) {
+		atomic.AddInt64(&handled, 1)
+		if task == 0 {
+			<-release
+			return struct{}{}, errFirst
+		}
+		return struct{}{}, nil
+	})
+	rp.SetNumShards(1)
+	rp.SetShardMinWorkers(1)
+	rp.SetShardMaxWorkers(1)
+	rp.SetQueueSize(64)
+	rp.Start()
+	defer rp.Stop()
+
+	// Task 0 occupies the only worker; the rest queue up behind it.
+	g := rp.NewGroup(context.Background())
+	for i := 0; i < 50; i++ {
+		g.Go(i)
+	}
+	close(release)
+
+	if err := g.Wait(); err != errFirst {
+		t.Fatalf("Wait: got %v, want errFirst", err)
+	}
+	if got := atomic.LoadInt64(&handled); got != 1 {
+		t.Errorf("handled tasks: got %d, want 1 (pending tasks must be skipped)", got)
+	}
+
+	// Go on a failed group does not submit.
+	g.Go(100)
+	if got := atomic.LoadInt64(&handled); got != 1 {
+		t.Errorf("handled tasks after Go on failed group: got %d, want 1", got)
+	}
+
+	// The shared pool keeps serving other groups.
+	other := rp.NewGroup(context.Background())
+	other.Go(1)
+	if err := other.Wait(); err != nil {
+		t.Errorf("other.Wait: got %v, want nil", err)
+	}
+}
+
+func TestGroupParentCanceled(t *testing.T) {
+	rp := NewResultPool(func(task int) (struct{}, error) {
+		return struct{}{}, nil
+	})
+	rp.SetNumShards(1)
+	rp.Start()
+	defer rp.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g := rp.NewGroup(ctx)
+	cancel()
+	g.Go(1)
+
+	if err := g.Wait(); err != context.Canceled {
+		t.Errorf("Wait: got %v, want context.Canceled", err)
+	}
+}
+
+func TestWorkerPoolGroup(t *testing.T) {
+	var handled int64
+
+	wp := NewWorkerPool(func(task int) {
+		atomic.AddInt64(&handled, 1)
+	})
+	wp.SetNumShards(2)
+	wp.Start()
+	defer wp.Stop()
+
+	g1 := wp.NewGroup(context.Background())
+	g2 := wp.NewGroup(context.Background())
+	for i := 0; i < 100; i++ {
+		g1.Go(i)
+		g2.Go(i)
+	}
+
+	if err := g1.Wait(); err != nil {
+		t.Errorf("g1.Wait: got %v, want nil", err)
+	}
+	if err := g2.Wait(); err != nil {
+		t.Errorf("g2.Wait: got %v, want nil", err)
+	}
+	if got := atomic.LoadInt64(&handled); got != 200 {
+		t.Errorf("handled tasks: got %d, want 200", got)
+	}
+}
+
+func TestWorkerPoolGroupPanicCancelsPending(t *testing.T) {
+	release := make(chan struct{})
+	var handled int64
+
+	wp := NewWorkerPool(func(task int) {
+		atomic.AddInt64(&handled, 1)
+		if task == 0 {
+			<-release
+			panic("first")
+		}
+	})
+	wp.SetNumShards(1)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.SetQueueSize(64)
+	wp.SetPanicHandler(func(task int, recovered any, stack []byte) {})
+	wp.Start()
+	defer wp.Stop()
+
+	// Task 0 occupies the only worker; the rest queue up behind it.
+	g := wp.NewGroup(context.Background())
+	for i := 0; i < 50; i++ {
+		g.Go(i)
+	}
+	close(release)
+
+	if err := g.Wait(); err != ErrTaskPanicked {
+		t.Fatalf("Wait: got %v, want ErrTaskPanicked", err)
+	}
+	if got := atomic.LoadInt64(&handled); got != 1 {
+		t.Errorf("handled tasks: got %d, want 1 (pending tasks must be skipped)", got)
+	}
+
+	// Skipped tasks still count as completed, so the pool goes idle.
+	wp.Wait()
+	if got := wp.Stats().Completed; got != 50 {
+		t.Errorf("completed tasks: got %d, want 50", got)
+	}
+}
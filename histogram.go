@@ -0,0 +1,167 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Durations are bucketed log-linearly (HDR style): values below
+// histSubBuckets get one bucket each, every power of two above that is split
+// into histSubBuckets linear sub-buckets. That bounds the relative error to
+// 1/histSubBuckets (6.25%) from 1ns up to 2^histMaxExp ns (~18 minutes);
+// longer durations land in the last bucket.
+const histSubBits = 4
+const histSubBuckets = 1 << histSubBits
+const histMaxExp = 40
+const histNumBuckets = (histMaxExp-histSubBits+1)*histSubBuckets + histSubBuckets
+
+// histogram is a lock-free duration histogram; record is safe for
+// concurrent use.
+type histogram struct {
+	counts [histNumBuckets]uint64
+	sum    uint64
+}
+
+func histBucket(ns int64) int {
+	if ns < histSubBuckets {
+		if ns < 0 {
+			return 0
+		}
+		return int(ns)
+	}
+
+	exp := bits.Len64(uint64(ns)) - 1
+	if exp > histMaxExp {
+		return histNumBuckets - 1
+	}
+	sub := int(uint64(ns)>>(exp-histSubBits)) & (histSubBuckets - 1)
+
+	return (exp-histSubBits+1)*histSubBuckets + sub
+}
+
+// histBucketMax returns the largest duration (in ns) that maps to bucket i
+func histBucketMax(i int) int64 {
+	if i < histSubBuckets {
+		return int64(i)
+	}
+
+	exp := i/histSubBuckets + histSubBits - 1
+	sub := int64(i % histSubBuckets)
+	width := int64(1) << (exp - histSubBits)
+
+	return (histSubBuckets+sub)*width + width - 1
+}
+
+func (h *histogram) record(ns int64) {
+	atomic.AddUint64(&h.counts[histBucket(ns)], 1)
+	atomic.AddUint64(&h.sum, uint64(ns))
+}
+
+func (h *histogram) snapshot() *histogramSnapshot {
+	s := &histogramSnapshot{}
+	for i := range h.counts {
+		s.counts[i] = atomic.LoadUint64(&h.counts[i])
+		s.count += s.counts[i]
+	}
+	s.sum = atomic.LoadUint64(&h.sum)
+
+	return s
+}
+
+type histogramSnapshot struct {
+	counts [histNumBuckets]uint64
+	count  uint64
+	sum    uint64
+}
+
+func (s *histogramSnapshot) merge(o *histogramSnapshot) {
+	for i := range s.counts {
+		s.counts[i] += o.counts[i]
+	}
+	s.count += o.count
+	s.sum += o.sum
+}
+
+func (s *histogramSnapshot) quantile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(s.count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, n := range s.counts {
+		seen += n
+		if seen >= rank {
+			return time.Duration(histBucketMax(i))
+		}
+	}
+
+	return time.Duration(histBucketMax(histNumBuckets - 1))
+}
+
+func (s *histogramSnapshot) countAtOrBelow(d time.Duration) uint64 {
+	var n uint64
+	for i, c := range s.counts {
+		if histBucketMax(i) > int64(d) {
+			break
+		}
+		n += c
+	}
+
+	return n
+}
+
+// LatencyStats summarizes a duration histogram recorded in timing mode
+// (see SetTaskTiming).
+type LatencyStats struct {
+	Count uint64
+	Sum   time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+
+	hist *histogramSnapshot
+}
+
+func newLatencyStats(s *histogramSnapshot) LatencyStats {
+	return LatencyStats{
+		Count: s.count,
+		Sum:   time.Duration(s.sum),
+		P50:   s.quantile(0.5),
+		P90:   s.quantile(0.9),
+		P99:   s.quantile(0.99),
+		P999:  s.quantile(0.999),
+		hist:  s,
+	}
+}
+
+// Returns the duration below which a fraction q (0..1) of the samples fall,
+// accurate to the histogram's bucket resolution
+func (ls LatencyStats) Quantile(q float64) time.Duration {
+	if ls.hist == nil {
+		return 0
+	}
+
+	return ls.hist.quantile(q)
+}
+
+// Returns the number of samples of at most d. Samples are only counted if
+// their whole bucket lies at or below d, so the result may undercount by up
+// to one bucket's worth.
+func (ls LatencyStats) CountAtOrBelow(d time.Duration) uint64 {
+	if ls.hist == nil {
+		return 0
+	}
+
+	return ls.hist.countAtOrBelow(d)
+}
@@ -0,0 +1,70 @@
+package ultrapool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	// Every value must land in a bucket whose range contains it, and bucket
+	// ranges must be contiguous.
+	for _, ns := range []int64{0, 1, 15, 16, 17, 31, 32, 33, 63, 64, 1000, 123456789, 1 << 40, 1<<41 - 1} {
+		i := histBucket(ns)
+		lower := int64(0)
+		if i > 0 {
+			lower = histBucketMax(i-1) + 1
+		}
+		if ns < lower || ns > histBucketMax(i) {
+			t.Errorf("value %d: bucket %d covers [%d, %d]", ns, i, lower, histBucketMax(i))
+		}
+	}
+
+	if got := histBucket(1 << 50); got != histNumBuckets-1 {
+		t.Errorf("overflow value: got bucket %d, want %d", got, histNumBuckets-1)
+	}
+	if got := histBucket(-5); got != 0 {
+		t.Errorf("negative value: got bucket %d, want 0", got)
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 1000; i++ {
+		h.record(int64(i) * int64(time.Microsecond))
+	}
+
+	ls := newLatencyStats(h.snapshot())
+	if ls.Count != 1000 {
+		t.Fatalf("Count: got %d, want 1000", ls.Count)
+	}
+	if want := 500500 * time.Microsecond; ls.Sum != want {
+		t.Errorf("Sum: got %v, want %v", ls.Sum, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"P50", ls.P50, 500 * time.Microsecond},
+		{"P90", ls.P90, 900 * time.Microsecond},
+		{"P99", ls.P99, 990 * time.Microsecond},
+		{"P999", ls.P999, 999 * time.Microsecond},
+	} {
+		// Bucket resolution bounds the relative error to 1/histSubBuckets.
+		if tt.got < tt.want || float64(tt.got) > float64(tt.want)*(1+1.0/histSubBuckets) {
+			t.Errorf("%s: got %v, want within [%v, +%.2f%%]", tt.name, tt.got, tt.want, 100.0/histSubBuckets)
+		}
+	}
+
+	if got := ls.CountAtOrBelow(2 * time.Millisecond); got != 1000 {
+		t.Errorf("CountAtOrBelow(2ms): got %d, want 1000", got)
+	}
+	// 1ms falls inside a bucket, whose samples are left out.
+	if got := ls.CountAtOrBelow(time.Millisecond); got > 1000 || got < 1000-1000/histSubBuckets {
+		t.Errorf("CountAtOrBelow(1ms): got %d, want within [%d, 1000]", got, 1000-1000/histSubBuckets)
+	}
+	if got := ls.CountAtOrBelow(0); got != 0 {
+		t.Errorf("CountAtOrBelow(0): got %d, want 0", got)
+	}
+}
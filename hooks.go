@@ -0,0 +1,63 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"time"
+)
+
+// WorkerExitReason tells why a worker exited.
+type WorkerExitReason int
+
+const (
+	// The worker was above its shard's floor and idle for idleWorkerLifetime.
+	WorkerExitIdle WorkerExitReason = iota
+	// The pool was stopped and the worker's shard queue is drained.
+	WorkerExitStop
+	// The worker exceeded a cap lowered via Reconfigure.
+	WorkerExitExcess
+	// The worker's shard was removed by ResizeShards and its queue is drained.
+	WorkerExitRemoved
+)
+
+func (r WorkerExitReason) String() string {
+	switch r {
+	case WorkerExitIdle:
+		return "idle"
+	case WorkerExitStop:
+		return "stop"
+	case WorkerExitExcess:
+		return "excess"
+	case WorkerExitRemoved:
+		return "removed"
+	}
+
+	return "unknown"
+}
+
+// Hooks are optional lifecycle callbacks; any of them may be nil. All hooks
+// run synchronously on the worker goroutine they concern, so worker hooks
+// may e.g. call runtime.LockOSThread or set pprof labels for the worker.
+type Hooks[T any] struct {
+	// Called when a worker starts, before it takes its first task.
+	OnWorkerStart func(shard int)
+
+	// Called when a worker exits. StopAndWait waits for these to return.
+	OnWorkerExit func(shard int, reason WorkerExitReason)
+
+	// Called before the task handler runs.
+	OnTaskStart func(shard int, task T)
+
+	// Called after the task handler returned or panicked. recovered is the
+	// panic value; panics are only recovered with a panic handler set (see
+	// SetPanicHandler), otherwise they crash the process before this runs.
+	OnTaskDone func(shard int, task T, duration time.Duration, recovered any)
+}
+
+// Sets the lifecycle hooks. Must be called before Start. Without hooks the
+// task path only pays a nil check.
+func (wp *WorkerPool[T]) SetHooks(hooks *Hooks[T]) {
+	wp.checkMutable("SetHooks")
+	wp.hooks = hooks
+}
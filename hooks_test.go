@@ -0,0 +1,126 @@
+package ultrapool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHooksWorkerLifecycle(t *testing.T) {
+	const shards = 2
+	const minWorkers = 2
+	const maxWorkers = 6
+
+	var mu sync.Mutex
+	started := map[int]int{}
+	exited := map[WorkerExitReason]int{}
+
+	wp := NewWorkerPool(func(task int) {
+		time.Sleep(5 * time.Millisecond)
+	})
+	wp.SetNumShards(shards)
+	wp.SetShardMinWorkers(minWorkers)
+	wp.SetShardMaxWorkers(maxWorkers)
+	wp.SetIdleWorkerLifetime(20 * time.Millisecond)
+	wp.SetHooks(&Hooks[int]{
+		OnWorkerStart: func(shard int) {
+			mu.Lock()
+			started[shard]++
+			mu.Unlock()
+		},
+		OnWorkerExit: func(shard int, reason WorkerExitReason) {
+			time.Sleep(time.Millisecond) // StopAndWait must wait for this
+			mu.Lock()
+			exited[reason]++
+			mu.Unlock()
+		},
+	})
+	wp.Start()
+
+	for i := 0; i < 100; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.Wait()
+
+	// Let the burst workers retire back to the floor.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && wp.GetSpawnedWorkers() > shards*minWorkers {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	wp.StopAndWait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	total := 0
+	for shard, n := range started {
+		if shard < 0 || shard >= shards {
+			t.Errorf("OnWorkerStart: unexpected shard index %d", shard)
+		}
+		total += n
+	}
+	if uint64(total) != wp.Stats().Spawned {
+		t.Errorf("OnWorkerStart calls: got %d, want %d (spawned workers)", total, wp.Stats().Spawned)
+	}
+	if exited[WorkerExitStop] != shards*minWorkers {
+		t.Errorf("OnWorkerExit(stop) calls: got %d, want %d", exited[WorkerExitStop], shards*minWorkers)
+	}
+	if exited[WorkerExitStop]+exited[WorkerExitIdle] != total {
+		t.Errorf("OnWorkerExit calls: got %d stop + %d idle, want %d in total",
+			exited[WorkerExitStop], exited[WorkerExitIdle], total)
+	}
+}
+
+func TestHooksTaskLifecycle(t *testing.T) {
+	var startedTasks, doneTasks, panicked int64
+	var minDuration int64 = -1
+
+	wp := NewWorkerPool(func(task int) {
+		if task == 3 {
+			panic("three")
+		}
+		time.Sleep(time.Millisecond)
+	})
+	wp.SetNumShards(1)
+	wp.SetPanicHandler(func(task int, recovered any, stack []byte) {})
+	wp.SetHooks(&Hooks[int]{
+		OnTaskStart: func(shard int, task int) {
+			atomic.AddInt64(&startedTasks, 1)
+		},
+		OnTaskDone: func(shard int, task int, duration time.Duration, recovered any) {
+			atomic.AddInt64(&doneTasks, 1)
+			if recovered != nil {
+				if task != 3 || recovered != "three" {
+					t.Errorf("OnTaskDone(%d): unexpected panic value %v", task, recovered)
+				}
+				atomic.AddInt64(&panicked, 1)
+				return
+			}
+			if d := int64(duration); atomic.LoadInt64(&minDuration) < 0 || d < atomic.LoadInt64(&minDuration) {
+				atomic.StoreInt64(&minDuration, d)
+			}
+		},
+	})
+	wp.Start()
+
+	for i := 0; i < 10; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.StopAndWait()
+
+	if startedTasks != 10 || doneTasks != 10 {
+		t.Errorf("task hooks: got %d starts and %d dones, want 10 each", startedTasks, doneTasks)
+	}
+	if panicked != 1 {
+		t.Errorf("OnTaskDone with panic info: got %d, want 1", panicked)
+	}
+	if time.Duration(minDuration) < time.Millisecond {
+		t.Errorf("shortest reported duration: got %v, want >= 1ms", time.Duration(minDuration))
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// KeyFunc returns the key of a task for keyed dispatch (see SetKeyFunc).
+type KeyFunc[T any] func(task T) uint64
+
+// number of independently locked parts of the key backlog map
+const numKeyStripes = 64
+
+// Sets a function that derives a key from each task. With a key func set,
+// AddTask and its variants (including delayed tasks) dispatch every task
+// like AddTaskKeyed with its key. Must be called before Start.
+func (wp *WorkerPool[T]) SetKeyFunc(fn KeyFunc[T]) {
+	wp.checkMutable("SetKeyFunc")
+	wp.keyFunc = fn
+}
+
+// Enables key serialization: keyed tasks with the same key never run
+// concurrently and run in the order they were added, while tasks with
+// different keys still run in parallel. A task whose predecessor with the
+// same key is still queued or running waits in a per-key backlog (of up to
+// the queue size) and is run by the worker of its predecessor right after
+// it. This holds across work stealing and ResizeShards. Must be called
+// before Start.
+func (wp *WorkerPool[T]) SetKeySerialization(enabled bool) {
+	wp.checkMutable("SetKeySerialization")
+	wp.keySerialization = enabled
+}
+
+// Adds a new task to the shard the key hashes to, so that related tasks
+// share a shard and its workers' caches. The tasks of a shard start in the
+// order they were added, but its workers run them in parallel, steal them
+// (see SetWorkStealing), and ResizeShards may move a key to another shard.
+// Use SetKeySerialization for strict per-key ordering.
+func (wp *WorkerPool[T]) AddTaskKeyed(key uint64, task T) error {
+	if !wp.started {
+		return errors.New("worker pool must be started first")
+	}
+	if atomic.LoadInt32(&wp.stopped) != 0 {
+		return ErrPoolStopped
+	}
+
+	return wp.submitKeyed(laneNormal, key, task)
+}
+
+// addTaskKeyed adds a task to the given priority lane of the shard its key
+// hashes to
+func (wp *WorkerPool[T]) addTaskKeyed(lane int, key uint64, task T, countRejected bool) error {
+	return wp.addQueuedKeyed(lane, key, QueuedTask[T]{task: task}, countRejected)
+}
+
+// addQueuedKeyed is addTaskKeyed for a task along with its meta data
+func (wp *WorkerPool[T]) addQueuedKeyed(lane int, key uint64, qt QueuedTask[T], countRejected bool) error {
+	hash := keyHash(key)
+	if wp.keys != nil {
+		return wp.keys.add(wp, lane, hash, key, qt, countRejected)
+	}
+
+	for {
+		shards := wp.shards.Load().shards
+		err := shards[hash%uint64(len(shards))].dispatchCounted(lane, qt, countRejected)
+		if err != errShardRemoved {
+			return err
+		}
+	}
+}
+
+// keyHash mixes the bits of a key (SplitMix64 finalizer), so that
+// sequential keys spread over the shards
+func keyHash(key uint64) uint64 {
+	z := key + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+// keySerializer keeps track of the keys with a task queued or running. The
+// first task of a key is queued as usual; later ones wait in the key's
+// backlog until the worker running their predecessor takes them.
+type keySerializer[T any] struct {
+	stripes [numKeyStripes]keyStripe[T]
+}
+
+type keyStripe[T any] struct {
+	mutex sync.Mutex
+	// backlog holds an entry (possibly nil) for every key with a task queued
+	// or running
+	backlog map[uint64][]keyedTask[T]
+}
+
+// keyedTask is a task waiting for its predecessor with the same key
+type keyedTask[T any] struct {
+	shard *poolShard[T] // the shard that accounts for the task
+	qt    QueuedTask[T]
+}
+
+func newKeySerializer[T any]() *keySerializer[T] {
+	ks := &keySerializer[T]{}
+	for i := range ks.stripes {
+		ks.stripes[i].backlog = make(map[uint64][]keyedTask[T])
+	}
+
+	return ks
+}
+
+func (ks *keySerializer[T]) stripe(hash uint64) *keyStripe[T] {
+	return &ks.stripes[(hash>>32)%numKeyStripes]
+}
+
+// add dispatches a task, or appends it to the backlog of its key if a task
+// with the same key is queued or running. The stripe stays locked while
+// dispatching, so a finishing predecessor cannot miss the task.
+func (ks *keySerializer[T]) add(wp *WorkerPool[T], lane int, hash, key uint64, qt QueuedTask[T], countRejected bool) error {
+	stripe := ks.stripe(hash)
+	stripe.mutex.Lock()
+	defer stripe.mutex.Unlock()
+
+	qt.meta = qt.meta.clone()
+	qt.meta.key, qt.meta.keyed = key, true
+	for {
+		shards := wp.shards.Load().shards
+		shard := shards[hash%uint64(len(shards))]
+
+		backlog, busy := stripe.backlog[key]
+		if !busy {
+			err := shard.dispatchCounted(lane, qt, countRejected)
+			if err == nil {
+				stripe.backlog[key] = nil
+			}
+			if err != errShardRemoved {
+				return err
+			}
+			continue
+		}
+
+		if len(backlog) >= wp.queueSize {
+			if countRejected {
+				atomic.AddUint64(&shard.rejected, 1)
+			}
+			return ErrPoolOverload
+		}
+		if err := shard.admit(); err != nil {
+			if err != errShardRemoved {
+				return err
+			}
+			continue
+		}
+		if shard.timing != nil {
+			qt.meta.enqueuedAt = nanotime()
+		}
+		stripe.backlog[key] = append(backlog, keyedTask[T]{shard: shard, qt: qt})
+		atomic.AddInt64(&shard.backlogged, 1)
+
+		return nil
+	}
+}
+
+// runBacklog runs the tasks that were added behind a finished task with the
+// same key, until the key's backlog is empty
+func (ks *keySerializer[T]) runBacklog(handler TaskHandlerFunc[T], key uint64) {
+	stripe := ks.stripe(keyHash(key))
+	for {
+		stripe.mutex.Lock()
+		backlog := stripe.backlog[key]
+		if len(backlog) == 0 {
+			delete(stripe.backlog, key)
+			stripe.mutex.Unlock()
+			return
+		}
+		next := backlog[0]
+		backlog[0] = keyedTask[T]{}
+		stripe.backlog[key] = backlog[1:]
+		stripe.mutex.Unlock()
+
+		atomic.AddInt64(&next.shard.backlogged, -1)
+		next.shard.run(handler, next.qt)
+	}
+}
+
+// admit accounts for a task that bypasses the shard's queue. Like dispatch,
+// it refuses tasks once the pool is stopped or the shard is removed.
+func (shard *poolShard[T]) admit() error {
+	shard.tqLock.RLock()
+	defer shard.tqLock.RUnlock()
+
+	if atomic.LoadInt32(&shard.wp.stopped) != 0 {
+		return ErrPoolStopped
+	}
+	if shard.removed {
+		return errShardRemoved
+	}
+	atomic.AddUint64(&shard.submitted, 1)
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package ultrapool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAddTaskKeyed(t *testing.T) {
+	const numKeys = 32
+
+	var mutex sync.Mutex
+	shardOf := make(map[uint64]map[int]bool)
+	wp := NewWorkerPool(func(key uint64) {})
+	wp.SetNumShards(4)
+	wp.hooks = &Hooks[uint64]{
+		OnTaskStart: func(shard int, key uint64) {
+			mutex.Lock()
+			if shardOf[key] == nil {
+				shardOf[key] = make(map[int]bool)
+			}
+			shardOf[key][shard] = true
+			mutex.Unlock()
+		},
+	}
+	wp.Start()
+
+	for i := 0; i < 10; i++ {
+		for key := uint64(0); key < numKeys; key++ {
+			if err := wp.AddTaskKeyed(key, key); err != nil {
+				t.Fatalf("AddTaskKeyed(%d): %v", key, err)
+			}
+		}
+	}
+	wp.StopAndWait()
+
+	used := make(map[int]bool)
+	for key, shards := range shardOf {
+		if len(shards) != 1 {
+			t.Errorf("key %d ran on %d shards, want 1", key, len(shards))
+		}
+		for shard := range shards {
+			used[shard] = true
+		}
+	}
+	if len(shardOf) != numKeys {
+		t.Errorf("keys seen: got %d, want %d", len(shardOf), numKeys)
+	}
+	if len(used) < 2 {
+		t.Errorf("%d keys all hashed to one shard", numKeys)
+	}
+}
+
+type keyedEvent struct {
+	key uint64
+	seq int
+}
+
+func TestKeySerialization(t *testing.T) {
+	const numKeys = 8
+	const numEvents = 200
+
+	tests := []struct {
+		name         string
+		workStealing bool
+	}{
+		{"plain", false},
+		{"work stealing", true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var running [numKeys]int32
+			var overlaps int32
+			seen := make([][]int, numKeys)
+
+			wp := NewWorkerPool(func(ev keyedEvent) {
+				if atomic.AddInt32(&running[ev.key], 1) != 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				seen[ev.key] = append(seen[ev.key], ev.seq)
+				if ev.seq%10 == 0 {
+					time.Sleep(100 * time.Microsecond)
+				}
+				atomic.AddInt32(&running[ev.key], -1)
+			})
+			wp.SetNumShards(2)
+			wp.SetShardMinWorkers(4)
+			wp.SetWorkStealing(tt.workStealing)
+			wp.SetKeyFunc(func(ev keyedEvent) uint64 { return ev.key })
+			wp.SetKeySerialization(true)
+			wp.Start()
+
+			for seq := 0; seq < numEvents; seq++ {
+				for key := uint64(0); key < numKeys; key++ {
+					if err := wp.AddTaskWithBlocking(keyedEvent{key, seq}); err != nil {
+						t.Fatalf("AddTaskWithBlocking: %v", err)
+					}
+				}
+			}
+			wp.StopAndWait()
+
+			if overlaps != 0 {
+				t.Errorf("tasks with the same key overlapped %d times", overlaps)
+			}
+			for key, seqs := range seen {
+				if len(seqs) != numEvents {
+					t.Errorf("key %d: got %d tasks, want %d", key, len(seqs), numEvents)
+					continue
+				}
+				for i, seq := range seqs {
+					if seq != i {
+						t.Errorf("key %d: task %d ran as #%d", key, seq, i)
+						break
+					}
+				}
+			}
+
+			stats := wp.Stats()
+			if stats.Completed != numKeys*numEvents {
+				t.Errorf("completed tasks: got %d, want %d", stats.Completed, numKeys*numEvents)
+			}
+		})
+	}
+}
+
+func TestKeySerializationBacklog(t *testing.T) {
+	const queueSize = 16
+
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	var ran int32
+	wp := NewWorkerPool(func(task int) {
+		if task < 0 {
+			close(blocked)
+			<-release
+			return
+		}
+		atomic.AddInt32(&ran, 1)
+	})
+	wp.SetNumShards(2)
+	wp.SetQueueSize(queueSize)
+	wp.SetKeySerialization(true)
+	wp.Start()
+
+	if err := wp.AddTaskKeyed(1, -1); err != nil {
+		t.Fatalf("AddTaskKeyed: %v", err)
+	}
+	<-blocked
+
+	// Other keys are not held up.
+	if err := wp.AddTaskKeyed(2, 0); err != nil {
+		t.Fatalf("AddTaskKeyed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&ran) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&ran) != 1 {
+		t.Fatal("task of another key did not run")
+	}
+
+	for i := 0; i < queueSize; i++ {
+		if err := wp.AddTaskKeyed(1, i); err != nil {
+			t.Fatalf("AddTaskKeyed(%d): %v", i, err)
+		}
+	}
+	if err := wp.AddTaskKeyed(1, queueSize); err != ErrPoolOverload {
+		t.Errorf("AddTaskKeyed on a full backlog: got %v, want ErrPoolOverload", err)
+	}
+
+	stats := wp.Stats()
+	if stats.Rejected != 1 {
+		t.Errorf("rejected tasks: got %d, want 1", stats.Rejected)
+	}
+	if stats.KeyBacklog != queueSize || stats.BusyWorkers != 1 {
+		t.Errorf("key backlog and busy workers: got %d and %d, want %d and 1", stats.KeyBacklog, stats.BusyWorkers, queueSize)
+	}
+
+	// The backlog drains on Stop.
+	close(release)
+	wp.StopAndWait()
+	if got := atomic.LoadInt32(&ran); got != queueSize+1 {
+		t.Errorf("tasks run: got %d, want %d", got, queueSize+1)
+	}
+}
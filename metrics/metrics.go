@@ -0,0 +1,272 @@
+// Package metrics exports ultrapool statistics in the Prometheus text
+// exposition format.
+//
+// It deliberately does not import the Prometheus client library, so the
+// ultrapool module stays free of dependencies. A Collector renders the
+// Stats() of every registered pool and doubles as an http.Handler for a
+// /metrics endpoint:
+//
+//	c := metrics.NewCollector("")
+//	c.Register("ingest", wp)
+//	http.Handle("/metrics", c)
+//
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maurice2k/ultrapool/v2"
+)
+
+const defaultNamespace = "ultrapool"
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// StatsProvider is implemented by every ultrapool.WorkerPool[T] and
+// ultrapool.ResultPool[T, R].
+type StatsProvider interface {
+	Stats() ultrapool.Stats
+}
+
+// Collector renders the statistics of a set of named pools.
+type Collector struct {
+	namespace string
+	mutex     sync.Mutex
+	pools     map[string]StatsProvider
+}
+
+type shardFamily struct {
+	name  string
+	help  string
+	typ   string
+	value func(ss *ultrapool.ShardStats) uint64
+}
+
+var shardFamilies = []shardFamily{
+	{"queue_length", "Tasks buffered in the shard queue.", "gauge",
+		func(ss *ultrapool.ShardStats) uint64 { return uint64(ss.QueueLen) }},
+	{"key_backlog_length", "Keyed tasks waiting for their predecessor with the same key.", "gauge",
+		func(ss *ultrapool.ShardStats) uint64 { return uint64(ss.KeyBacklog) }},
+	{"workers", "Currently spawned workers.", "gauge",
+		func(ss *ultrapool.ShardStats) uint64 { return uint64(ss.Workers) }},
+	{"busy_workers", "Workers executing a task.", "gauge",
+		func(ss *ultrapool.ShardStats) uint64 { return uint64(ss.BusyWorkers) }},
+	{"tasks_completed_total", "Tasks executed.", "counter",
+		func(ss *ultrapool.ShardStats) uint64 { return ss.Completed }},
+	{"tasks_rejected_total", "Submissions rejected because the pool was overloaded.", "counter",
+		func(ss *ultrapool.ShardStats) uint64 { return ss.Rejected }},
+	{"tasks_stolen_total", "Tasks taken by workers of other shards.", "counter",
+		func(ss *ultrapool.ShardStats) uint64 { return ss.Stolen }},
+	{"workers_spawned_total", "Workers spawned.", "counter",
+		func(ss *ultrapool.ShardStats) uint64 { return ss.Spawned }},
+	{"workers_retired_total", "Workers retired after their idle timeout or a lowered worker cap.", "counter",
+		func(ss *ultrapool.ShardStats) uint64 { return ss.Retired }},
+}
+
+// poolFamily is a pool-wide counter. Unlike the shard counters, which start
+// over for shards added by ResizeShards and vanish with removed ones, it
+// includes the shards that were removed.
+type poolFamily struct {
+	name  string
+	help  string
+	value func(stats *ultrapool.Stats) uint64
+}
+
+var poolFamilies = []poolFamily{
+	{"pool_tasks_completed_total", "Tasks executed, including those of removed shards.",
+		func(stats *ultrapool.Stats) uint64 { return stats.Completed }},
+	{"pool_tasks_rejected_total", "Submissions rejected because the pool was overloaded, including those of removed shards.",
+		func(stats *ultrapool.Stats) uint64 { return stats.Rejected }},
+	{"pool_tasks_discarded_total", "Tasks dropped by the rejection policy.",
+		func(stats *ultrapool.Stats) uint64 { return stats.Discarded }},
+	{"pool_tasks_stolen_total", "Tasks taken by workers of other shards, including those of removed shards.",
+		func(stats *ultrapool.Stats) uint64 { return stats.Stolen }},
+	{"pool_workers_spawned_total", "Workers spawned, including those of removed shards.",
+		func(stats *ultrapool.Stats) uint64 { return stats.Spawned }},
+	{"pool_workers_retired_total", "Workers retired after their idle timeout or a lowered worker cap, including those of removed shards.",
+		func(stats *ultrapool.Stats) uint64 { return stats.Retired }},
+}
+
+type latencyFamily struct {
+	name  string
+	help  string
+	value func(stats *ultrapool.Stats) *ultrapool.LatencyStats
+}
+
+var latencyFamilies = []latencyFamily{
+	{"task_queue_wait_seconds", "Time from dispatch until a worker picks the task up.",
+		func(stats *ultrapool.Stats) *ultrapool.LatencyStats { return &stats.QueueWait }},
+	{"task_execution_seconds", "Time spent in the task handler.",
+		func(stats *ultrapool.Stats) *ultrapool.LatencyStats { return &stats.Execution }},
+}
+
+// LatencyBuckets are the upper bounds of the exported latency histograms.
+var LatencyBuckets = []time.Duration{
+	time.Microsecond, 5 * time.Microsecond,
+	10 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// Creates a new Collector. Metric names are prefixed with namespace, or with
+// "ultrapool" if namespace is empty.
+func NewCollector(namespace string) *Collector {
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	return &Collector{
+		namespace: namespace,
+		pools:     make(map[string]StatsProvider),
+	}
+}
+
+// Registers a pool under the given name, which becomes its "pool" label.
+// Registering a name again replaces the previous pool.
+func (c *Collector) Register(name string, pool StatsProvider) {
+	c.mutex.Lock()
+	c.pools[name] = pool
+	c.mutex.Unlock()
+}
+
+// Removes the pool registered under the given name
+func (c *Collector) Unregister(name string) {
+	c.mutex.Lock()
+	delete(c.pools, name)
+	c.mutex.Unlock()
+}
+
+type poolSnapshot struct {
+	name  string
+	stats ultrapool.Stats
+}
+
+// snapshot collects the stats of all registered pools, ordered by name
+func (c *Collector) snapshot() []poolSnapshot {
+	c.mutex.Lock()
+	snapshots := make([]poolSnapshot, 0, len(c.pools))
+	for name, pool := range c.pools {
+		snapshots = append(snapshots, poolSnapshot{name: name, stats: pool.Stats()})
+	}
+	c.mutex.Unlock()
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].name < snapshots[j].name
+	})
+
+	return snapshots
+}
+
+// Writes the metrics of all registered pools in text exposition format.
+// Counters are written per shard, which ResizeShards resets, and as pool-wide
+// totals (prefixed with "pool_"), which are monotonic. Latency histograms
+// are only written for pools in timing mode (see
+// ultrapool.WorkerPool.SetTaskTiming) that have executed at least one task.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	snapshots := c.snapshot()
+
+	for _, f := range shardFamilies {
+		c.writeHeader(&buf, f.name, f.help, f.typ)
+		for _, ps := range snapshots {
+			for i := range ps.stats.Shards {
+				labels := poolLabel(ps.name) + `,shard="` + strconv.Itoa(i) + `"`
+				c.writeSample(&buf, f.name, labels, strconv.FormatUint(f.value(&ps.stats.Shards[i]), 10))
+			}
+		}
+	}
+
+	for _, f := range poolFamilies {
+		c.writeHeader(&buf, f.name, f.help, "counter")
+		for _, ps := range snapshots {
+			c.writeSample(&buf, f.name, poolLabel(ps.name), strconv.FormatUint(f.value(&ps.stats), 10))
+		}
+	}
+
+	c.writeHeader(&buf, "waiters", "Callers blocked waiting for queue capacity.", "gauge")
+	for _, ps := range snapshots {
+		c.writeSample(&buf, "waiters", poolLabel(ps.name), strconv.Itoa(ps.stats.Waiters))
+	}
+
+	for _, f := range latencyFamilies {
+		c.writeHeader(&buf, f.name, f.help, "histogram")
+		for _, ps := range snapshots {
+			ls := f.value(&ps.stats)
+			if ls.Count == 0 {
+				continue
+			}
+
+			labels := poolLabel(ps.name)
+			for _, le := range LatencyBuckets {
+				c.writeSample(&buf, f.name+"_bucket", labels+`,le="`+formatSeconds(le)+`"`,
+					strconv.FormatUint(ls.CountAtOrBelow(le), 10))
+			}
+			c.writeSample(&buf, f.name+"_bucket", labels+`,le="+Inf"`, strconv.FormatUint(ls.Count, 10))
+			c.writeSample(&buf, f.name+"_sum", labels, formatSeconds(ls.Sum))
+			c.writeSample(&buf, f.name+"_count", labels, strconv.FormatUint(ls.Count, 10))
+		}
+	}
+
+	return buf.WriteTo(w)
+}
+
+// Serves the metrics of all registered pools
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = c.WriteTo(w)
+}
+
+func (c *Collector) writeHeader(buf *bytes.Buffer, name, help, typ string) {
+	buf.WriteString("# HELP ")
+	buf.WriteString(c.namespace)
+	buf.WriteByte('_')
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	buf.WriteString(help)
+	buf.WriteString("\n# TYPE ")
+	buf.WriteString(c.namespace)
+	buf.WriteByte('_')
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	buf.WriteString(typ)
+	buf.WriteByte('\n')
+}
+
+// writeSample writes a single sample with already formatted labels and value
+func (c *Collector) writeSample(buf *bytes.Buffer, name, labels, value string) {
+	buf.WriteString(c.namespace)
+	buf.WriteByte('_')
+	buf.WriteString(name)
+	buf.WriteByte('{')
+	buf.WriteString(labels)
+	buf.WriteString("} ")
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+func poolLabel(pool string) string {
+	return `pool="` + escapeLabel(pool) + `"`
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/maurice2k/ultrapool/v2"
+)
+
+func TestCollectorServeHTTP(t *testing.T) {
+	wp := ultrapool.NewWorkerPool(func(task int) {})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.Start()
+	defer wp.Stop()
+
+	for i := 0; i < 10; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.Wait()
+
+	c := NewCollector("")
+	c.Register(`in"gest`, wp)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type: got %q, want %q", ct, ContentType)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE ultrapool_queue_length gauge\n",
+		"# TYPE ultrapool_tasks_completed_total counter\n",
+		`ultrapool_workers{pool="in\"gest",shard="0"} 1` + "\n",
+		`ultrapool_workers{pool="in\"gest",shard="1"} 1` + "\n",
+		`ultrapool_workers_spawned_total{pool="in\"gest",shard="1"} 1` + "\n",
+		`ultrapool_waiters{pool="in\"gest"} 0` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("output is missing %q:\n%s", want, body)
+		}
+	}
+
+	var completed int
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "ultrapool_tasks_completed_total{") {
+			n, err := strconv.Atoi(line[strings.LastIndexByte(line, ' ')+1:])
+			if err != nil {
+				t.Fatalf("parsing %q: %v", line, err)
+			}
+			completed += n
+		}
+	}
+	if completed != 10 {
+		t.Errorf("sum of ultrapool_tasks_completed_total: got %d, want 10", completed)
+	}
+}
+
+func TestCollectorPoolTotalsSurviveResizeShards(t *testing.T) {
+	wp := ultrapool.NewWorkerPool(func(task int) {})
+	wp.SetNumShards(4)
+	wp.Start()
+	defer wp.Stop()
+
+	for i := 0; i < 20; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.Wait()
+	if err := wp.ResizeShards(1); err != nil {
+		t.Fatalf("ResizeShards: %v", err)
+	}
+
+	c := NewCollector("")
+	c.Register("resized", wp)
+
+	var sb strings.Builder
+	if _, err := c.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	out := sb.String()
+	for _, want := range []string{
+		"# TYPE ultrapool_pool_tasks_completed_total counter\n",
+		`ultrapool_pool_tasks_completed_total{pool="resized"} 20` + "\n",
+		`ultrapool_pool_tasks_discarded_total{pool="resized"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCollectorNamespaceAndUnregister(t *testing.T) {
+	wp := ultrapool.NewWorkerPool(func(task int) {})
+	wp.SetNumShards(1)
+	wp.Start()
+	defer wp.Stop()
+
+	c := NewCollector("app")
+	c.Register("a", wp)
+	c.Register("b", wp)
+	c.Unregister("a")
+
+	var sb strings.Builder
+	if _, err := c.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	out := sb.String()
+	if !strings.Contains(out, `app_workers{pool="b",shard="0"}`) {
+		t.Errorf("output is missing the namespaced sample for pool b:\n%s", out)
+	}
+	if strings.Contains(out, `pool="a"`) {
+		t.Errorf("output still contains unregistered pool a:\n%s", out)
+	}
+}
+
+func TestCollectorLatencyHistograms(t *testing.T) {
+	wp := ultrapool.NewWorkerPool(func(task int) {})
+	wp.SetNumShards(1)
+	wp.SetTaskTiming(true)
+	wp.Start()
+	defer wp.Stop()
+
+	for i := 0; i < 5; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.Wait()
+
+	c := NewCollector("")
+	c.Register("timed", wp)
+
+	var sb strings.Builder
+	if _, err := c.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	out := sb.String()
+	for _, want := range []string{
+		"# TYPE ultrapool_task_execution_seconds histogram\n",
+		`ultrapool_task_execution_seconds_bucket{pool="timed",le="+Inf"} 5` + "\n",
+		`ultrapool_task_execution_seconds_count{pool="timed"} 5` + "\n",
+		`ultrapool_task_queue_wait_seconds_count{pool="timed"} 5` + "\n",
+		`ultrapool_task_queue_wait_seconds_bucket{pool="timed",le="1e-06"} `,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Option configures a WorkerPool[T] created by NewWorkerPoolWithOptions. The
+// options that don't depend on the task type take it as a type parameter as
+// well (e.g. WithNumShards[Job](8)), so that an option for another task type
+// is a compile error.
+type Option[T any] func(o *options[T]) error
+
+type options[T any] struct {
+	maxWorkers         int
+	queueSize          int
+	shardMinWorkers    int
+	shardMaxWorkers    int
+	numShards          int
+	numShardsSet       bool
+	idleWorkerLifetime time.Duration
+	taskTiming         bool
+	panicHandler       PanicHandlerFunc[T]
+	hooks              *Hooks[T]
+	delayPolicy        DelayPolicy[T]
+	overflowPolicy     *OverflowPolicy[T]
+	rejectionPolicy    RejectionPolicy[T]
+	keyFunc            KeyFunc[T]
+	autoTuning         *AutoTuning
+	priorityMode       PriorityMode
+	priorityWeights    [numLanes]int
+	workStealing       bool
+	keySerialization   bool
+	queueKind          QueueKind
+	queueKindSet       bool
+	queueFactory       QueueFactory[T]
+}
+
+// Limits the total number of workers across all shards; 0 means no limit.
+func WithMaxWorkers[T any](n int) Option[T] {
+	return func(o *options[T]) error {
+		o.maxWorkers = n
+		return nil
+	}
+}
+
+// Sets the per-shard task queue capacity (at least 16).
+func WithQueueSize[T any](size int) Option[T] {
+	return func(o *options[T]) error {
+		if size < 16 {
+			return fmt.Errorf("ultrapool: queue size must be >= 16, got %d", size)
+		}
+		o.queueSize = size
+		return nil
+	}
+}
+
+// Sets the number of workers per shard that are started with the pool and
+// kept alive when idle (at least 1).
+func WithShardMinWorkers[T any](n int) Option[T] {
+	return func(o *options[T]) error {
+		o.shardMinWorkers = n
+		return nil
+	}
+}
+
+// Sets the maximum number of workers per shard (at least 1).
+func WithShardMaxWorkers[T any](n int) Option[T] {
+	return func(o *options[T]) error {
+		o.shardMaxWorkers = n
+		return nil
+	}
+}
+
+// Sets the number of shards (1 to 128). Without this option the number is
+// derived from GOMAXPROCS. Cannot be combined with WithAutoTuning, which
+// sets the number of shards itself.
+func WithNumShards[T any](n int) Option[T] {
+	return func(o *options[T]) error {
+		if n < 1 || n > maxShards {
+			return fmt.Errorf("ultrapool: number of shards must be in [1, %d], got %d", maxShards, n)
+		}
+		o.numShards = n
+		o.numShardsSet = true
+		return nil
+	}
+}
+
+// Sets how long a worker above its shard's floor may idle before it retires
+// (must be positive).
+func WithIdleWorkerLifetime[T any](d time.Duration) Option[T] {
+	return func(o *options[T]) error {
+		o.idleWorkerLifetime = d
+		return nil
+	}
+}
+
+// Enables timing mode (see SetTaskTiming).
+func WithTaskTiming[T any]() Option[T] {
+	return func(o *options[T]) error {
+		o.taskTiming = true
+		return nil
+	}
+}
+
+// Sets the panic handler (see SetPanicHandler).
+func WithPanicHandler[T any](handler PanicHandlerFunc[T]) Option[T] {
+	return func(o *options[T]) error {
+		if handler == nil {
+			return errors.New("ultrapool: panic handler must not be nil")
+		}
+		o.panicHandler = handler
+		return nil
+	}
+}
+
+// Sets the lifecycle hooks (see SetHooks).
+func WithHooks[T any](hooks *Hooks[T]) Option[T] {
+	return func(o *options[T]) error {
+		if hooks == nil {
+			return errors.New("ultrapool: hooks must not be nil")
+		}
+		o.hooks = hooks
+		return nil
+	}
+}
+
+// Sets the policy for delayed tasks (see SetDelayPolicy).
+func WithDelayPolicy[T any](policy DelayPolicy[T]) Option[T] {
+	return func(o *options[T]) error {
+		if policy.Overload < DelayOverloadDrop || policy.Overload > DelayOverloadBlock {
+			return fmt.Errorf("ultrapool: unknown delay overload policy %d", policy.Overload)
+		}
+		if policy.RetryInterval < 0 {
+			return fmt.Errorf("ultrapool: delay retry interval must be >= 0, got %v", policy.RetryInterval)
+		}
+		o.delayPolicy = policy
+		return nil
+	}
+}
+
+// Sets the policy for tasks that don't fit into their shard's queue (see
+// SetRejectionPolicy). RejectCustom requires a handler, and RejectDropOldest
+// cannot be combined with key serialization.
+func WithRejectionPolicy[T any](policy RejectionPolicy[T]) Option[T] {
+	return func(o *options[T]) error {
+		if policy.Action < RejectAbort || policy.Action > RejectCustom {
+			return fmt.Errorf("ultrapool: unknown rejection action %d", policy.Action)
+		}
+		if policy.Action == RejectCustom && policy.Handler == nil {
+			return errors.New("ultrapool: custom rejection requires a handler")
+		}
+		if policy.Timeout < 0 {
+			return fmt.Errorf("ultrapool: rejection timeout must be >= 0, got %v", policy.Timeout)
+		}
+		o.rejectionPolicy = policy
+		return nil
+	}
+}
+
+// Enables overflow mode (see SetOverflowPolicy). A byte budget requires a
+// size func, and the watermarks must satisfy 0 < low < high <= 1 once
+// defaulted. Overflow mode brings its own queues, so it cannot be combined
+// with WithQueueKind or WithQueueFactory.
+func WithOverflowPolicy[T any](policy OverflowPolicy[T]) Option[T] {
+	return func(o *options[T]) error {
+		if policy.MaxTasks < 0 {
+			return fmt.Errorf("ultrapool: overflow task limit must be >= 0, got %d", policy.MaxTasks)
+		}
+		if policy.MaxBytes < 0 {
+			return fmt.Errorf("ultrapool: overflow byte budget must be >= 0, got %d", policy.MaxBytes)
+		}
+		if policy.MaxBytes > 0 && policy.SizeFunc == nil {
+			return errors.New("ultrapool: overflow byte budget requires a size func")
+		}
+		policy = policy.withDefaults()
+		if policy.LowWatermark >= policy.HighWatermark || policy.HighWatermark > 1 {
+			return fmt.Errorf("ultrapool: overflow watermarks must satisfy 0 < low < high <= 1, got %v, %v", policy.LowWatermark, policy.HighWatermark)
+		}
+		o.overflowPolicy = &policy
+		return nil
+	}
+}
+
+// Selects the queue implementation of the shards (see SetQueueKind). Cannot
+// be combined with WithQueueFactory.
+func WithQueueKind[T any](kind QueueKind) Option[T] {
+	return func(o *options[T]) error {
+		if kind < QueueChan || kind > QueueStack {
+			return fmt.Errorf("ultrapool: unknown queue kind %d", kind)
+		}
+		o.queueKind = kind
+		o.queueKindSet = true
+		return nil
+	}
+}
+
+// Sets a factory for the shards' queues (see SetQueueFactory).
+func WithQueueFactory[T any](factory QueueFactory[T]) Option[T] {
+	return func(o *options[T]) error {
+		if factory == nil {
+			return errors.New("ultrapool: queue factory must not be nil")
+		}
+		o.queueFactory = factory
+		return nil
+	}
+}
+
+// Enables priority lanes (see SetPriorityMode).
+func WithPriorityMode[T any](mode PriorityMode) Option[T] {
+	return func(o *options[T]) error {
+		if mode < PriorityOff || mode > PriorityWeighted {
+			return fmt.Errorf("ultrapool: unknown priority mode %d", mode)
+		}
+		o.priorityMode = mode
+		return nil
+	}
+}
+
+// Sets the weights of the priority lanes in PriorityWeighted mode (see
+// SetPriorityWeights); each must be at least 1.
+func WithPriorityWeights[T any](high, normal, low int) Option[T] {
+	return func(o *options[T]) error {
+		if high < 1 || normal < 1 || low < 1 {
+			return fmt.Errorf("ultrapool: priority weights must be >= 1, got %d:%d:%d", high, normal, low)
+		}
+		o.priorityWeights = [numLanes]int{high, normal, low}
+		return nil
+	}
+}
+
+// Enables work stealing between shards (see SetWorkStealing).
+func WithWorkStealing[T any]() Option[T] {
+	return func(o *options[T]) error {
+		o.workStealing = true
+		return nil
+	}
+}
+
+// Dispatches tasks by the key fn derives from them (see SetKeyFunc).
+func WithKeyFunc[T any](fn KeyFunc[T]) Option[T] {
+	return func(o *options[T]) error {
+		if fn == nil {
+			return errors.New("ultrapool: key func must not be nil")
+		}
+		o.keyFunc = fn
+		return nil
+	}
+}
+
+// Runs keyed tasks with the same key one after another, in the order they
+// were added (see SetKeySerialization).
+func WithKeySerialization[T any]() Option[T] {
+	return func(o *options[T]) error {
+		o.keySerialization = true
+		return nil
+	}
+}
+
+// Enables the background tuner (see SetAutoTuning). The tuner may change the
+// number of shards and worker limits of the otherwise immutable pool.
+func WithAutoTuning[T any](at AutoTuning) Option[T] {
+	return func(o *options[T]) error {
+		if at.Interval < 0 {
+			return fmt.Errorf("ultrapool: auto tuning interval must be >= 0, got %v", at.Interval)
+		}
+		if at.MaxWorkersPerCPU < 0 {
+			return fmt.Errorf("ultrapool: auto tuning max workers per CPU must be >= 0, got %d", at.MaxWorkersPerCPU)
+		}
+		o.autoTuning = &at
+		return nil
+	}
+}
+
+// validate checks the worker limits, on their own and in combination, and
+// rejects options that contradict each other.
+func (o *options[T]) validate() error {
+	if o.overflowPolicy != nil && o.queueKindSet {
+		return errors.New("ultrapool: overflow mode cannot be combined with a queue kind")
+	}
+	if o.overflowPolicy != nil && o.queueFactory != nil {
+		return errors.New("ultrapool: overflow mode cannot be combined with a queue factory")
+	}
+	if o.queueKindSet && o.queueFactory != nil {
+		return errors.New("ultrapool: a queue kind cannot be combined with a queue factory")
+	}
+	if o.autoTuning != nil && o.numShardsSet {
+		return errors.New("ultrapool: auto tuning cannot be combined with a fixed number of shards")
+	}
+	if action := o.rejectionPolicy.Action; o.keySerialization &&
+		(action == RejectCallerRuns || action == RejectDropOldest || action == RejectCustom) {
+		return fmt.Errorf("ultrapool: %v rejection cannot be combined with key serialization", action)
+	}
+
+	cfg := Config{
+		MaxWorkers:         o.maxWorkers,
+		ShardMinWorkers:    o.shardMinWorkers,
+		ShardMaxWorkers:    o.shardMaxWorkers,
+		IdleWorkerLifetime: o.idleWorkerLifetime,
+	}
+
+	return cfg.validate(o.numShards)
+}
+
+// Creates a new WorkerPool with the given task handling function and
+// options. Unlike the Set* methods, options do not clamp: invalid values and
+// contradicting combinations are reported as errors.
+//
+// The configuration of the returned pool is immutable; its Set* methods
+// panic. Only the worker limits and the number of shards can be changed, via
+// Reconfigure, ResizeShards or the tuner (see WithAutoTuning).
+func NewWorkerPoolWithOptions[T any](handlerFunc TaskHandlerFunc[T], opts ...Option[T]) (*WorkerPool[T], error) {
+	if handlerFunc == nil {
+		return nil, errors.New("ultrapool: task handler must not be nil")
+	}
+
+	o := options[T]{
+		queueSize:          defaultQueueSize,
+		shardMinWorkers:    defaultShardMinWorkers,
+		shardMaxWorkers:    defaultShardMaxWorkers,
+		numShards:          defaultNumShards(),
+		idleWorkerLifetime: defaultIdleWorkerLifetime,
+		priorityWeights:    [numLanes]int{defaultPriorityWeightHigh, defaultPriorityWeightNormal, defaultPriorityWeightLow},
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	wp := NewWorkerPool(handlerFunc)
+	wp.maxWorkers = o.maxWorkers
+	wp.queueSize = o.queueSize
+	wp.shardMinWorkers = o.shardMinWorkers
+	wp.shardMaxWorkers = o.shardMaxWorkers
+	wp.numShards = o.numShards
+	wp.idleWorkerLifetime = o.idleWorkerLifetime
+	wp.taskTiming = o.taskTiming
+	wp.autoTuning = o.autoTuning
+	wp.priorityMode = o.priorityMode
+	wp.priorityWeights = o.priorityWeights
+	wp.workStealing = o.workStealing
+	wp.keySerialization = o.keySerialization
+	wp.queueKind = o.queueKind
+
+	wp.panicHandler = o.panicHandler
+	wp.hooks = o.hooks
+	wp.delayPolicy = o.delayPolicy
+	wp.rejection = o.rejectionPolicy
+	wp.overflowPolicy = o.overflowPolicy
+	wp.queueFactory = o.queueFactory
+	wp.keyFunc = o.keyFunc
+
+	wp.frozen = true
+
+	return wp, nil
+}
+
+// checkMutable panics if the pool's configuration is immutable, i.e. it was
+// created by NewWorkerPoolWithOptions
+func (wp *WorkerPool[T]) checkMutable(setter string) {
+	if wp.frozen {
+		panic("ultrapool: " + setter + " called on a pool created by NewWorkerPoolWithOptions, whose configuration is immutable")
+	}
+}
@@ -0,0 +1,165 @@
+package ultrapool
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewWorkerPoolWithOptions(t *testing.T) {
+	var panics int64
+
+	wp, err := NewWorkerPoolWithOptions(func(task int) {
+		if task == 0 {
+			panic("zero")
+		}
+	},
+		WithNumShards[int](3),
+		WithShardMinWorkers[int](2),
+		WithShardMaxWorkers[int](4),
+		WithMaxWorkers[int](12),
+		WithQueueSize[int](32),
+		WithIdleWorkerLifetime[int](50*time.Millisecond),
+		WithTaskTiming[int](),
+		WithPanicHandler(func(task int, recovered any, stack []byte) {
+			atomic.AddInt64(&panics, 1)
+		}),
+		WithHooks(&Hooks[int]{}),
+	)
+	if err != nil {
+		t.Fatalf("NewWorkerPoolWithOptions: %v", err)
+	}
+
+	if wp.numShards != 3 || wp.shardMinWorkers != 2 || wp.shardMaxWorkers != 4 || wp.maxWorkers != 12 ||
+		wp.queueSize != 32 || wp.idleWorkerLifetime != 50*time.Millisecond || !wp.taskTiming || wp.hooks == nil {
+		t.Errorf("options not applied: %+v", wp)
+	}
+
+	// The configuration is immutable.
+	for name, set := range map[string]func(){
+		"SetNumShards":          func() { wp.SetNumShards(8) },
+		"SetShardMinWorkers":    func() { wp.SetShardMinWorkers(1) },
+		"SetShardMaxWorkers":    func() { wp.SetShardMaxWorkers(100) },
+		"SetMaxWorkers":         func() { wp.SetMaxWorkers(0) },
+		"SetQueueSize":          func() { wp.SetQueueSize(1024) },
+		"SetIdleWorkerLifetime": func() { wp.SetIdleWorkerLifetime(time.Hour) },
+		"SetTaskTiming":         func() { wp.SetTaskTiming(false) },
+		"SetPanicHandler":       func() { wp.SetPanicHandler(nil) },
+		"SetHooks":              func() { wp.SetHooks(nil) },
+		"SetRejectionPolicy":    func() { wp.SetRejectionPolicy(RejectionPolicy[int]{}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s on an immutable pool did not panic", name)
+				}
+			}()
+			set()
+		}()
+	}
+	if wp.numShards != 3 || wp.shardMinWorkers != 2 || wp.shardMaxWorkers != 4 || wp.maxWorkers != 12 ||
+		wp.queueSize != 32 || wp.idleWorkerLifetime != 50*time.Millisecond || !wp.taskTiming ||
+		wp.panicHandler == nil || wp.hooks == nil {
+		t.Errorf("setters changed an immutable pool: %+v", wp)
+	}
+
+	wp.Start()
+	for i := 0; i < 10; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.StopAndWait()
+
+	if got := atomic.LoadInt64(&panics); got != 1 {
+		t.Errorf("panic handler calls: got %d, want 1", got)
+	}
+	if got := wp.Stats().Execution.Count; got != 10 {
+		t.Errorf("timed executions: got %d, want 10", got)
+	}
+}
+
+func TestNewWorkerPoolWithOptionsErrors(t *testing.T) {
+	handler := func(task int) {}
+
+	tests := []struct {
+		name    string
+		handler TaskHandlerFunc[int]
+		opts    []Option[int]
+		wantErr string
+	}{
+		{"nil handler", nil, nil, "task handler must not be nil"},
+		{"negative max workers", handler, []Option[int]{WithMaxWorkers[int](-1)}, "max workers must be >= 0"},
+		{"small queue", handler, []Option[int]{WithQueueSize[int](8)}, "queue size must be >= 16"},
+		{"zero shard min", handler, []Option[int]{WithShardMinWorkers[int](0)}, "shard min workers must be >= 1"},
+		{"zero shard max", handler, []Option[int]{WithShardMaxWorkers[int](0)}, "shard max workers must be >= 1"},
+		{"zero shards", handler, []Option[int]{WithNumShards[int](0)}, "number of shards must be in [1, 128]"},
+		{"too many shards", handler, []Option[int]{WithNumShards[int](maxShards + 1)}, "number of shards must be in [1, 128]"},
+		{"zero idle lifetime", handler, []Option[int]{WithIdleWorkerLifetime[int](0)}, "idle worker lifetime must be > 0"},
+		{"min above max", handler, []Option[int]{WithShardMinWorkers[int](8), WithShardMaxWorkers[int](4)},
+			"shard min workers (8) exceed shard max workers (4)"},
+		{"floor above global cap", handler, []Option[int]{WithNumShards[int](4), WithShardMinWorkers[int](3), WithMaxWorkers[int](10)},
+			"4 shards with 3 min workers each need 12 workers, but max workers is 10"},
+		{"nil panic handler", handler, []Option[int]{WithPanicHandler[int](nil)}, "panic handler must not be nil"},
+		{"unknown delay overload", handler, []Option[int]{WithDelayPolicy(DelayPolicy[int]{Overload: DelayOverloadBlock + 1})},
+			"unknown delay overload policy 3"},
+		{"unknown rejection action", handler, []Option[int]{WithRejectionPolicy(RejectionPolicy[int]{Action: RejectCustom + 1})},
+			"unknown rejection action 6"},
+		{"custom rejection without handler", handler, []Option[int]{WithRejectionPolicy(RejectionPolicy[int]{Action: RejectCustom})},
+			"custom rejection requires a handler"},
+		{"negative rejection timeout", handler, []Option[int]{WithRejectionPolicy(RejectionPolicy[int]{Action: RejectBlock, Timeout: -1})},
+			"rejection timeout must be >= 0"},
+		{"drop oldest with key serialization", handler,
+			[]Option[int]{WithRejectionPolicy(RejectionPolicy[int]{Action: RejectDropOldest}), WithKeySerialization[int]()},
+			"drop-oldest rejection cannot be combined with key serialization"},
+		{"caller runs with key serialization", handler,
+			[]Option[int]{WithKeySerialization[int](), WithRejectionPolicy(RejectionPolicy[int]{Action: RejectCallerRuns})},
+			"caller-runs rejection cannot be combined with key serialization"},
+		{"custom rejection with key serialization", handler,
+			[]Option[int]{WithRejectionPolicy(RejectionPolicy[int]{Action: RejectCustom, Handler: func(int) error { return nil }}), WithKeySerialization[int]()},
+			"custom rejection cannot be combined with key serialization"},
+		{"negative overflow limit", handler, []Option[int]{WithOverflowPolicy(OverflowPolicy[int]{MaxTasks: -1})},
+			"overflow task limit must be >= 0, got -1"},
+		{"overflow bytes without size func", handler, []Option[int]{WithOverflowPolicy(OverflowPolicy[int]{MaxBytes: 1 << 20})},
+			"overflow byte budget requires a size func"},
+		{"inverted overflow watermarks", handler, []Option[int]{WithOverflowPolicy(OverflowPolicy[int]{HighWatermark: 0.5, LowWatermark: 0.7})},
+			"overflow watermarks must satisfy 0 < low < high <= 1, got 0.7, 0.5"},
+		{"unknown queue kind", handler, []Option[int]{WithQueueKind[int](QueueStack + 1)}, "unknown queue kind 4"},
+		{"nil queue factory", handler, []Option[int]{WithQueueFactory[int](nil)}, "queue factory must not be nil"},
+		{"unknown priority mode", handler, []Option[int]{WithPriorityMode[int](PriorityWeighted + 1)}, "unknown priority mode 3"},
+		{"zero priority weight", handler, []Option[int]{WithPriorityWeights[int](4, 0, 1)}, "priority weights must be >= 1, got 4:0:1"},
+		{"nil key func", handler, []Option[int]{WithKeyFunc[int](nil)}, "key func must not be nil"},
+		{"overflow with queue kind", handler,
+			[]Option[int]{WithOverflowPolicy(OverflowPolicy[int]{}), WithQueueKind[int](QueueRing)},
+			"overflow mode cannot be combined with a queue kind"},
+		{"overflow with queue factory", handler,
+			[]Option[int]{WithQueueFactory(func(capacity int) TaskQueue[QueuedTask[int]] { return nil }), WithOverflowPolicy(OverflowPolicy[int]{})},
+			"overflow mode cannot be combined with a queue factory"},
+		{"queue kind with queue factory", handler,
+			[]Option[int]{WithQueueKind[int](QueueRing), WithQueueFactory(func(capacity int) TaskQueue[QueuedTask[int]] { return nil })},
+			"a queue kind cannot be combined with a queue factory"},
+		{"auto tuning with shard count", handler, []Option[int]{WithNumShards[int](4), WithAutoTuning[int](AutoTuning{})},
+			"auto tuning cannot be combined with a fixed number of shards"},
+		{"negative tuning interval", handler, []Option[int]{WithAutoTuning[int](AutoTuning{Interval: -1})},
+			"auto tuning interval must be >= 0"},
+		{"negative workers per CPU", handler, []Option[int]{WithAutoTuning[int](AutoTuning{MaxWorkersPerCPU: -1})},
+			"auto tuning max workers per CPU must be >= 0"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			wp, err := NewWorkerPoolWithOptions(tt.handler, tt.opts...)
+			if err == nil {
+				t.Fatalf("got pool %+v, want error containing %q", wp, tt.wantErr)
+			}
+			if wp != nil {
+				t.Error("got a non-nil pool along with an error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error: got %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultOverflowHighWatermark = 0.8
+
+// OverflowPolicy configures overflow mode (see SetOverflowPolicy).
+type OverflowPolicy[T any] struct {
+	// Limit on the number of tasks queued in all shards; 0 means no limit.
+	MaxTasks int
+
+	// Limit on the total size of the tasks queued in all shards, as
+	// estimated by SizeFunc; 0 means no limit. A task larger than MaxBytes
+	// is only accepted while no other task is queued.
+	MaxBytes int64
+
+	// Estimates the memory a task takes up while queued, in bytes (at most
+	// 2 GiB). Called once per task on AddTask.
+	SizeFunc func(task T) int
+
+	// Fractions of the limits at which the watermark callbacks fire, with
+	// 0 < LowWatermark < HighWatermark <= 1. Unset, HighWatermark defaults to
+	// 0.8 and LowWatermark to half of HighWatermark.
+	HighWatermark float64
+	LowWatermark  float64
+
+	// Called once the queued tasks or their size reach the high watermark
+	// of their limit, and once both are back at or below the low watermark
+	// afterwards. The calls alternate and never overlap; OnHighWatermark
+	// runs on the goroutine that adds the task, OnLowWatermark on the worker
+	// that takes one, so both should return quickly. Either may be nil.
+	OnHighWatermark func(tasks int, bytes int64)
+	OnLowWatermark  func(tasks int, bytes int64)
+}
+
+// Enables overflow mode, for producers that would rather buffer than be
+// rejected: the shards queue tasks in unbounded queues (see QueueUnbounded;
+// SetQueueKind, SetQueueFactory and SetQueueSize have no effect), and AddTask only returns
+// ErrPoolOverload once the queued tasks exceed the policy's task count or
+// byte budget. The watermark callbacks let producers throttle well before
+// that. Tasks waiting in key backlogs (see SetKeySerialization) do not count.
+// A nil policy disables overflow mode. Must be called before Start.
+func (wp *WorkerPool[T]) SetOverflowPolicy(policy *OverflowPolicy[T]) {
+	wp.checkMutable("SetOverflowPolicy")
+	if policy == nil {
+		wp.overflowPolicy = nil
+		return
+	}
+
+	p := policy.withDefaults()
+	if p.MaxTasks < 0 {
+		p.MaxTasks = 0
+	}
+	if p.MaxBytes < 0 || p.SizeFunc == nil {
+		p.MaxBytes = 0
+	}
+	if p.HighWatermark > 1 {
+		p.HighWatermark = 1
+	}
+	if p.LowWatermark >= p.HighWatermark {
+		p.LowWatermark = p.HighWatermark / 2
+	}
+	wp.overflowPolicy = &p
+}
+
+// withDefaults returns the policy with unset watermarks set to their
+// defaults
+func (p OverflowPolicy[T]) withDefaults() OverflowPolicy[T] {
+	if p.HighWatermark <= 0 {
+		p.HighWatermark = defaultOverflowHighWatermark
+	}
+	if p.LowWatermark <= 0 {
+		p.LowWatermark = p.HighWatermark / 2
+	}
+
+	return p
+}
+
+// overflow tracks the tasks queued pool-wide in overflow mode
+type overflow[T any] struct {
+	wp       *WorkerPool[T]
+	policy   OverflowPolicy[T]
+	maxTasks int64
+
+	// watermarks in tasks and bytes
+	highTasks, lowTasks int64
+	highBytes, lowBytes int64
+
+	mutex sync.Mutex // serializes the watermark callbacks
+	high  int32      // above the high watermark; set under mutex
+
+	tasks int64
+	bytes int64
+}
+
+func newOverflow[T any](wp *WorkerPool[T], policy OverflowPolicy[T]) *overflow[T] {
+	return &overflow[T]{
+		wp:        wp,
+		policy:    policy,
+		maxTasks:  int64(policy.MaxTasks),
+		highTasks: int64(math.Ceil(float64(policy.MaxTasks) * policy.HighWatermark)),
+		lowTasks:  int64(float64(policy.MaxTasks) * policy.LowWatermark),
+		highBytes: int64(math.Ceil(float64(policy.MaxBytes) * policy.HighWatermark)),
+		lowBytes:  int64(float64(policy.MaxBytes) * policy.LowWatermark),
+	}
+}
+
+// reserve accounts for a task about to be queued and records its size in
+// qt. Returns false if that would exceed a limit.
+func (of *overflow[T]) reserve(qt *QueuedTask[T]) bool {
+	var size int64
+	if of.policy.SizeFunc != nil {
+		size = int64(of.policy.SizeFunc(qt.task))
+		if size < 0 {
+			size = 0
+		} else if size > math.MaxInt32 {
+			size = math.MaxInt32
+		}
+	}
+
+	tasks := atomic.AddInt64(&of.tasks, 1)
+	bytes := atomic.AddInt64(&of.bytes, size)
+	if (of.maxTasks > 0 && tasks > of.maxTasks) ||
+		(of.policy.MaxBytes > 0 && bytes > of.policy.MaxBytes && tasks > 1) {
+		atomic.AddInt64(&of.tasks, -1)
+		atomic.AddInt64(&of.bytes, -size)
+		return false
+	}
+	if size > 0 {
+		qt.meta = qt.meta.clone()
+		qt.meta.size = int32(size)
+	}
+
+	if atomic.LoadInt32(&of.high) == 0 && of.aboveHigh(tasks, bytes) {
+		of.crossWatermark()
+	}
+
+	return true
+}
+
+// release accounts for a task taken from a queue
+func (of *overflow[T]) release(qt QueuedTask[T]) {
+	tasks := atomic.AddInt64(&of.tasks, -1)
+	bytes := atomic.AddInt64(&of.bytes, -qt.size())
+
+	if atomic.LoadInt32(&of.high) != 0 && of.belowLow(tasks, bytes) {
+		of.crossWatermark()
+	}
+	of.wp.notifyWaiter()
+}
+
+func (of *overflow[T]) aboveHigh(tasks, bytes int64) bool {
+	return (of.maxTasks > 0 && tasks >= of.highTasks) ||
+		(of.policy.MaxBytes > 0 && bytes >= of.highBytes)
+}
+
+func (of *overflow[T]) belowLow(tasks, bytes int64) bool {
+	return (of.maxTasks == 0 || tasks <= of.lowTasks) &&
+		(of.policy.MaxBytes == 0 || bytes <= of.lowBytes)
+}
+
+// crossWatermark flips the watermark state and fires its callback if the
+// current counts still confirm the crossing
+func (of *overflow[T]) crossWatermark() {
+	of.mutex.Lock()
+	defer of.mutex.Unlock()
+
+	tasks, bytes := of.load()
+	if atomic.LoadInt32(&of.high) == 0 {
+		if of.aboveHigh(tasks, bytes) {
+			atomic.StoreInt32(&of.high, 1)
+			if of.policy.OnHighWatermark != nil {
+				of.policy.OnHighWatermark(int(tasks), bytes)
+			}
+		}
+	} else if of.belowLow(tasks, bytes) {
+		atomic.StoreInt32(&of.high, 0)
+		if of.policy.OnLowWatermark != nil {
+			of.policy.OnLowWatermark(int(tasks), bytes)
+		}
+	}
+}
+
+func (of *overflow[T]) load() (tasks int64, bytes int64) {
+	return atomic.LoadInt64(&of.tasks), atomic.LoadInt64(&of.bytes)
+}
@@ -0,0 +1,154 @@
+package ultrapool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// watermarkRecorder records the watermark callbacks of an overflow policy.
+type watermarkRecorder struct {
+	mutex  sync.Mutex
+	events []string
+	tasks  []int
+}
+
+func (wr *watermarkRecorder) record(event string) func(tasks int, bytes int64) {
+	return func(tasks int, bytes int64) {
+		wr.mutex.Lock()
+		wr.events = append(wr.events, event)
+		wr.tasks = append(wr.tasks, tasks)
+		wr.mutex.Unlock()
+	}
+}
+
+func (wr *watermarkRecorder) get() ([]string, []int) {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+
+	return append([]string(nil), wr.events...), append([]int(nil), wr.tasks...)
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	const queueSize = 16
+	const maxTasks = 100
+
+	wr := &watermarkRecorder{}
+	wp, _, releaseAll := engageBlockedPoolWith(t, 1, queueSize, time.Hour, func(wp *WorkerPool[int]) {
+		wp.SetOverflowPolicy(&OverflowPolicy[int]{
+			MaxTasks:        maxTasks,
+			HighWatermark:   0.5,
+			LowWatermark:    0.2,
+			OnHighWatermark: wr.record("high"),
+			OnLowWatermark:  wr.record("low"),
+		})
+	})
+	defer releaseAll()
+
+	// Far more than queueSize tasks are buffered.
+	for i := 0; i < maxTasks; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask(%d): %v", i, err)
+		}
+	}
+	if err := wp.AddTask(maxTasks); err != ErrPoolOverload {
+		t.Errorf("AddTask beyond the limit: got %v, want ErrPoolOverload", err)
+	}
+	stats := wp.Stats()
+	if stats.QueueLen != maxTasks || stats.Rejected != 1 {
+		t.Errorf("stats: got queue length %d, rejected %d, want %d, 1", stats.QueueLen, stats.Rejected, maxTasks)
+	}
+	if events, tasks := wr.get(); len(events) != 1 || events[0] != "high" || tasks[0] != 50 {
+		t.Errorf("watermark callbacks: got %v at %v, want [high] at [50]", events, tasks)
+	}
+
+	releaseAll()
+	wp.StopAndWait()
+
+	if got := wp.Stats().Completed; got != maxTasks+1 {
+		t.Errorf("completed tasks: got %d, want %d", got, maxTasks+1)
+	}
+	events, tasks := wr.get()
+	if len(events) != 2 || events[1] != "low" || tasks[1] > 20 {
+		t.Errorf("watermark callbacks: got %v at %v, want [high low] with low at <= 20", events, tasks)
+	}
+}
+
+func TestOverflowPolicyBytes(t *testing.T) {
+	const queueSize = 16
+
+	wp, _, releaseAll := engageBlockedPoolWith(t, 1, queueSize, time.Hour, func(wp *WorkerPool[int]) {
+		wp.SetOverflowPolicy(&OverflowPolicy[int]{
+			MaxBytes: 1000,
+			SizeFunc: func(task int) int { return task },
+		})
+	})
+	defer releaseAll()
+
+	for i := 0; i < 25; i++ {
+		if err := wp.AddTask(40); err != nil {
+			t.Fatalf("AddTask #%d: %v", i, err)
+		}
+	}
+	if err := wp.AddTask(1); err != ErrPoolOverload {
+		t.Errorf("AddTask beyond the byte budget: got %v, want ErrPoolOverload", err)
+	}
+	if got := wp.Stats().QueuedBytes; got != 1000 {
+		t.Errorf("queued bytes: got %d, want 1000", got)
+	}
+
+	// A task blocked in AddTaskWithBlocking gets in once space is freed.
+	done := make(chan error)
+	go func() {
+		done <- wp.AddTaskWithBlocking(30)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("AddTaskWithBlocking returned %v on a full pool", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	releaseAll()
+	if err := <-done; err != nil {
+		t.Errorf("AddTaskWithBlocking: %v", err)
+	}
+
+	// A task above the budget is accepted on its own.
+	wp.Wait()
+	if got := wp.Stats().QueuedBytes; got != 0 {
+		t.Errorf("queued bytes when idle: got %d, want 0", got)
+	}
+	if err := wp.AddTask(5000); err != nil {
+		t.Errorf("AddTask of a large task on an empty pool: %v", err)
+	}
+	wp.StopAndWait()
+}
+
+func TestOverflowPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    OverflowPolicy[int]
+		wantHigh  float64
+		wantLow   float64
+		wantBytes int64
+	}{
+		{"unset", OverflowPolicy[int]{}, 0.8, 0.4, 0},
+		{"high only", OverflowPolicy[int]{HighWatermark: 0.6}, 0.6, 0.3, 0},
+		{"high above 1", OverflowPolicy[int]{HighWatermark: 2, LowWatermark: 0.5}, 1, 0.5, 0},
+		{"low above high", OverflowPolicy[int]{HighWatermark: 0.5, LowWatermark: 0.7}, 0.5, 0.25, 0},
+		{"bytes without size func", OverflowPolicy[int]{MaxBytes: 100}, 0.8, 0.4, 0},
+		{"bytes", OverflowPolicy[int]{MaxBytes: 100, SizeFunc: func(int) int { return 1 }}, 0.8, 0.4, 100},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			wp := NewWorkerPool(func(task int) {})
+			wp.SetOverflowPolicy(&tt.policy)
+			p := wp.overflowPolicy
+			if p.HighWatermark != tt.wantHigh || p.LowWatermark != tt.wantLow || p.MaxBytes != tt.wantBytes {
+				t.Errorf("got high %v, low %v, bytes %d, want %v, %v, %d",
+					p.HighWatermark, p.LowWatermark, p.MaxBytes, tt.wantHigh, tt.wantLow, tt.wantBytes)
+			}
+		})
+	}
+}
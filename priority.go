@@ -0,0 +1,196 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"context"
+	"time"
+)
+
+// Priority of a task added with AddTaskPriority. Higher values are more
+// urgent; the zero value is PriorityNormal.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// PriorityMode selects how workers choose between the priority lanes of
+// their shard.
+type PriorityMode int
+
+const (
+	// A single queue per shard; task priorities are ignored (the default).
+	PriorityOff PriorityMode = iota
+	// Workers always take the most urgent task available, so lower lanes only
+	// run while the higher ones are empty.
+	PriorityStrict
+	// Workers take tasks from the lanes in proportion to the priority
+	// weights (see SetPriorityWeights), falling back to the other lanes when
+	// the preferred one is empty, so no lane starves.
+	PriorityWeighted
+)
+
+const (
+	laneHigh = iota
+	laneNormal
+	laneLow
+	numLanes
+)
+
+const defaultPriorityWeightHigh = 4
+const defaultPriorityWeightNormal = 2
+const defaultPriorityWeightLow = 1
+
+// laneOf maps a priority to its lane; out of range priorities are clamped
+func laneOf(p Priority) int {
+	if p > PriorityHigh {
+		p = PriorityHigh
+	}
+	if p < PriorityLow {
+		p = PriorityLow
+	}
+
+	return int(PriorityHigh - p)
+}
+
+// shardLanes are the priority lanes of a shard; the normal lane is the
+// shard's taskQueue. Every lane has its own capacity of queueSize, so
+// whether a task is rejected with ErrPoolOverload only depends on its lane.
+type shardLanes[T any] struct {
+	queues   [numLanes]chan QueuedTask[T]
+	schedule []int8 // preferred lane per turn in weighted mode; nil in strict mode
+}
+
+// Selects how workers dequeue from the priority lanes. With PriorityStrict or
+// PriorityWeighted each shard gets a high, normal and low priority lane of
+// SetQueueSize capacity each. An unknown mode resets to PriorityOff. Must be
+// called before Start.
+func (wp *WorkerPool[T]) SetPriorityMode(mode PriorityMode) {
+	wp.checkMutable("SetPriorityMode")
+	if mode < PriorityOff || mode > PriorityWeighted {
+		mode = PriorityOff
+	}
+	wp.priorityMode = mode
+}
+
+// Sets the relative share of tasks that workers take from the high, normal
+// and low priority lane in PriorityWeighted mode (default 4:2:1). Values
+// below 1 are clamped to 1. Must be called before Start.
+func (wp *WorkerPool[T]) SetPriorityWeights(high, normal, low int) {
+	wp.checkMutable("SetPriorityWeights")
+	for _, w := range []*int{&high, &normal, &low} {
+		if *w < 1 {
+			*w = 1
+		}
+	}
+	wp.priorityWeights = [numLanes]int{high, normal, low}
+}
+
+// newLanes creates the priority lanes for a shard whose normal lane is
+// taskQueue, or returns nil if priorities are off
+func (wp *WorkerPool[T]) newLanes(taskQueue chan QueuedTask[T]) *shardLanes[T] {
+	if wp.priorityMode == PriorityOff {
+		return nil
+	}
+
+	lanes := &shardLanes[T]{}
+	lanes.queues[laneHigh] = make(chan QueuedTask[T], wp.queueSize)
+	lanes.queues[laneNormal] = taskQueue
+	lanes.queues[laneLow] = make(chan QueuedTask[T], wp.queueSize)
+	if wp.priorityMode == PriorityWeighted {
+		lanes.schedule = weightedSchedule(wp.priorityWeights)
+	}
+
+	return lanes
+}
+
+// weightedSchedule spreads the lanes over one round of sum(weights) turns,
+// interleaved by smooth weighted round-robin (e.g. 4:2:1 yields
+// H N H L H N H).
+func weightedSchedule(weights [numLanes]int) []int8 {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	schedule := make([]int8, 0, total)
+	var current [numLanes]int
+	for len(schedule) < total {
+		best := 0
+		for lane := range weights {
+			current[lane] += weights[lane]
+			if current[lane] > current[best] {
+				best = lane
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, int8(best))
+	}
+
+	return schedule
+}
+
+// poll takes a task from the lanes without blocking, trying the preferred
+// lane of this turn first and the others in priority order after it. ok is
+// false if all lanes are empty; closed is set if the lanes are closed on top
+// (no more tasks will arrive then).
+func (lanes *shardLanes[T]) poll(turn *int) (qt QueuedTask[T], ok bool, closed bool) {
+	first := laneHigh
+	if lanes.schedule != nil {
+		first = int(lanes.schedule[*turn%len(lanes.schedule)])
+		*turn++
+	}
+
+	for i := -1; i < numLanes; i++ {
+		lane := i
+		if i < 0 {
+			lane = first
+		} else if i == first {
+			continue
+		}
+
+		select {
+		case qt, ok = <-lanes.queues[lane]:
+			if ok {
+				return qt, true, false
+			}
+			closed = true
+		default:
+		}
+	}
+
+	return qt, false, closed
+}
+
+// wait blocks until a task arrives in any lane, a lane is closed or wake
+// receives (ok is false in both cases) or timeout fires (timedOut). Nil
+// channels never fire.
+func (lanes *shardLanes[T]) wait(timeout <-chan time.Time, wake <-chan struct{}) (qt QueuedTask[T], ok bool, timedOut bool) {
+	select {
+	case qt, ok = <-lanes.queues[laneHigh]:
+	case qt, ok = <-lanes.queues[laneNormal]:
+	case qt, ok = <-lanes.queues[laneLow]:
+	case <-wake:
+	case <-timeout:
+		timedOut = true
+	}
+
+	return qt, ok, timedOut
+}
+
+// Adds a new task with the given priority. Without priority lanes (see
+// SetPriorityMode) this is the same as AddTask. Returns ErrPoolOverload if
+// the task's lane is full, regardless of the other lanes.
+func (wp *WorkerPool[T]) AddTaskPriority(priority Priority, task T) error {
+	return wp.submit(laneOf(priority), task)
+}
+
+// Adds a new task with the given priority and blocks until submitted, ctx is
+// done or the pool is stopped (see AddTaskWithBlockingContext).
+func (wp *WorkerPool[T]) AddTaskPriorityWithBlockingContext(ctx context.Context, priority Priority, task T) error {
+	return wp.addTaskWithBlocking(ctx, laneOf(priority), task)
+}
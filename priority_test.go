@@ -0,0 +1,181 @@
+package ultrapool
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeightedSchedule(t *testing.T) {
+	for _, tt := range []struct {
+		weights [numLanes]int
+		want    []int8
+	}{
+		{[numLanes]int{4, 2, 1}, []int8{laneHigh, laneNormal, laneHigh, laneLow, laneHigh, laneNormal, laneHigh}},
+		{[numLanes]int{1, 1, 1}, []int8{laneHigh, laneNormal, laneLow}},
+		{[numLanes]int{1, 3, 1}, []int8{laneNormal, laneHigh, laneNormal, laneLow, laneNormal}},
+	} {
+		if got := weightedSchedule(tt.weights); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("weightedSchedule(%v): got %v, want %v", tt.weights, got, tt.want)
+		}
+	}
+}
+
+// blockedPriorityPool returns a started single-worker pool in the given
+// priority mode whose worker is blocked until release is closed. Executed
+// tasks are appended to *order.
+func blockedPriorityPool(t *testing.T, mode PriorityMode, order *[]Priority) (wp *WorkerPool[Priority], release chan struct{}) {
+	var mu sync.Mutex
+	release = make(chan struct{})
+	started := make(chan struct{})
+
+	first := true
+	wp = NewWorkerPool(func(p Priority) {
+		mu.Lock()
+		if first {
+			first = false
+			mu.Unlock()
+			close(started)
+			<-release
+			return
+		}
+		*order = append(*order, p)
+		mu.Unlock()
+	})
+	wp.SetNumShards(1)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.SetQueueSize(64)
+	wp.SetPriorityMode(mode)
+	wp.Start()
+
+	if err := wp.AddTask(PriorityNormal); err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+	<-started
+
+	return wp, release
+}
+
+func TestPriorityStrict(t *testing.T) {
+	var order []Priority
+	wp, release := blockedPriorityPool(t, PriorityStrict, &order)
+
+	for i := 0; i < 10; i++ {
+		for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+			if err := wp.AddTaskPriority(p, p); err != nil {
+				t.Fatalf("AddTaskPriority(%d): %v", p, err)
+			}
+		}
+	}
+	if got := wp.Stats().QueueLen; got != 30 {
+		t.Errorf("queue length: got %d, want 30", got)
+	}
+
+	close(release)
+	wp.StopAndWait()
+
+	if len(order) != 30 {
+		t.Fatalf("executed tasks: got %d, want 30", len(order))
+	}
+	for i, p := range order {
+		want := PriorityHigh - Priority(i/10)
+		if p != want {
+			t.Fatalf("task %d: got priority %d, want %d (order %v)", i, p, want, order)
+		}
+	}
+}
+
+func TestPriorityWeighted(t *testing.T) {
+	var order []Priority
+	wp, release := blockedPriorityPool(t, PriorityWeighted, &order)
+
+	for i := 0; i < 20; i++ {
+		for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+			if err := wp.AddTaskPriority(p, p); err != nil {
+				t.Fatalf("AddTaskPriority(%d): %v", p, err)
+			}
+		}
+	}
+
+	close(release)
+	wp.StopAndWait()
+
+	if len(order) != 60 {
+		t.Fatalf("executed tasks: got %d, want 60", len(order))
+	}
+
+	// Two full rounds of the default 4:2:1 schedule.
+	counts := map[Priority]int{}
+	for _, p := range order[:14] {
+		counts[p]++
+	}
+	if counts[PriorityHigh] != 8 || counts[PriorityNormal] != 4 || counts[PriorityLow] != 2 {
+		t.Errorf("first 14 tasks by priority: got %v, want 8 high, 4 normal, 2 low", counts)
+	}
+}
+
+func TestPriorityOverloadPerLane(t *testing.T) {
+	var order []Priority
+	wp, release := blockedPriorityPool(t, PriorityStrict, &order)
+
+	for i := 0; i < 64; i++ {
+		if err := wp.AddTaskPriority(PriorityLow, PriorityLow); err != nil {
+			t.Fatalf("AddTaskPriority(low) #%d: %v", i, err)
+		}
+	}
+	if err := wp.AddTaskPriority(PriorityLow, PriorityLow); err != ErrPoolOverload {
+		t.Errorf("AddTaskPriority(low) on a full lane: got %v, want ErrPoolOverload", err)
+	}
+
+	// A flood of low priority tasks leaves the other lanes' capacity alone.
+	if err := wp.AddTaskPriority(PriorityHigh, PriorityHigh); err != nil {
+		t.Errorf("AddTaskPriority(high) with a full low lane: %v", err)
+	}
+	if err := wp.AddTask(PriorityNormal); err != nil {
+		t.Errorf("AddTask with a full low lane: %v", err)
+	}
+
+	close(release)
+	wp.StopAndWait()
+
+	if len(order) != 66 {
+		t.Fatalf("executed tasks: got %d, want 66", len(order))
+	}
+	if order[0] != PriorityHigh || order[1] != PriorityNormal {
+		t.Errorf("first tasks: got %v, want high, normal", order[:2])
+	}
+}
+
+func TestPriorityOff(t *testing.T) {
+	var order []Priority
+	wp, release := blockedPriorityPool(t, PriorityOff, &order)
+
+	// Without lanes, priorities are ignored and tasks run in FIFO order.
+	for _, p := range []Priority{PriorityLow, PriorityHigh + 5, PriorityNormal} {
+		if err := wp.AddTaskPriority(p, p); err != nil {
+			t.Fatalf("AddTaskPriority(%d): %v", p, err)
+		}
+	}
+
+	close(release)
+	if !wp.StopWithTimeout(2 * time.Second) {
+		t.Fatal("StopWithTimeout timed out")
+	}
+
+	want := []Priority{PriorityLow, PriorityHigh + 5, PriorityNormal}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("execution order: got %v, want %v", order, want)
+	}
+}
+
+func TestSetPriorityModeUnknown(t *testing.T) {
+	for _, mode := range []PriorityMode{PriorityMode(-1), PriorityWeighted + 1} {
+		wp := NewWorkerPool(func(task int) {})
+		wp.SetPriorityMode(mode)
+		if wp.priorityMode != PriorityOff {
+			t.Errorf("SetPriorityMode(%d): got mode %d, want PriorityOff", mode, wp.priorityMode)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TaskQueue is a non-blocking, concurrency-safe queue of tasks, as used per
+// shard (and per priority lane) by the pool. The pool parks and wakes its
+// workers itself, so implementations only need to be safe for concurrent
+// Push and Pop calls from many goroutines.
+type TaskQueue[E any] interface {
+	// Adds an element; returns false if the queue is full.
+	Push(elem E) bool
+	// Removes an element; returns false if the queue is empty.
+	Pop() (E, bool)
+	// Returns the number of queued elements.
+	Len() int
+}
+
+// QueueKind selects the TaskQueue implementation of a pool's shards.
+type QueueKind int
+
+const (
+	// A buffered channel (the default). Workers park on the channel itself.
+	QueueChan QueueKind = iota
+	// A lock-free bounded MPMC ring buffer (see NewRingQueue).
+	QueueRing
+	// An unbounded queue of linked segments (see NewUnboundedQueue). AddTask
+	// never returns ErrPoolOverload, so memory is the only limit.
+	QueueUnbounded
+	// A bounded LIFO stack (see NewStackQueue). The most recently added task
+	// runs first, while its data is likely still in the CPU cache; older
+	// tasks may starve under sustained load.
+	QueueStack
+)
+
+func (k QueueKind) String() string {
+	switch k {
+	case QueueChan:
+		return "chan"
+	case QueueRing:
+		return "ring"
+	case QueueUnbounded:
+		return "unbounded"
+	case QueueStack:
+		return "stack"
+	}
+
+	return fmt.Sprintf("QueueKind(%d)", int(k))
+}
+
+// Selects the queue implementation of the shards. Each shard (and each
+// priority lane) gets a queue of SetQueueSize capacity; the ring rounds it up
+// to a power of two and the unbounded queue ignores it. A queue factory (see
+// SetQueueFactory) takes precedence. Must be called before Start.
+func (wp *WorkerPool[T]) SetQueueKind(kind QueueKind) {
+	wp.checkMutable("SetQueueKind")
+	if kind < QueueChan || kind > QueueStack {
+		kind = QueueChan
+	}
+	wp.queueKind = kind
+}
+
+// QueueFactory creates a shard's queue (or one of its priority lanes) with
+// the given capacity.
+type QueueFactory[T any] func(capacity int) TaskQueue[QueuedTask[T]]
+
+// Sets a factory for the shards' queues, replacing the built-in queue kinds
+// (see SetQueueKind): each shard, and each priority lane, gets a queue of its
+// own, created with the SetQueueSize capacity. The queues must not block;
+// workers park and are woken by the pool. In overflow mode, the shards use
+// unbounded queues regardless. A nil factory restores the queue kind. Must be
+// called before Start.
+func (wp *WorkerPool[T]) SetQueueFactory(factory QueueFactory[T]) {
+	wp.checkMutable("SetQueueFactory")
+	wp.queueFactory = factory
+}
+
+// newTaskQueue creates a queue of the given kind for the shard's tasks
+func newTaskQueue[T any](kind QueueKind, capacity int) TaskQueue[QueuedTask[T]] {
+	switch kind {
+	case QueueRing:
+		return NewRingQueue[QueuedTask[T]](capacity)
+	case QueueUnbounded:
+		return NewUnboundedQueue[QueuedTask[T]]()
+	case QueueStack:
+		return NewStackQueue[QueuedTask[T]](capacity)
+	}
+
+	return NewChanQueue[QueuedTask[T]](capacity)
+}
+
+// queueSet is the worker side of a shard's queues unless it's a single task
+// channel: priority lanes, or queues of another kind
+type queueSet[T any] interface {
+	// poll takes a task without blocking; closed reports a closed queue
+	poll(turn *int) (qt QueuedTask[T], ok bool, closed bool)
+	// wait blocks until a task arrives, the queues are closed or wake
+	// receives (ok is false in both cases) or timeout fires (timedOut)
+	wait(timeout <-chan time.Time, wake <-chan struct{}) (qt QueuedTask[T], ok bool, timedOut bool)
+}
+
+// shardQueue holds a shard's TaskQueues (one per priority lane, or just
+// one) along with the channels workers park on
+type shardQueue[T any] struct {
+	lanes    []TaskQueue[QueuedTask[T]]
+	schedule []int8        // lane order in PriorityWeighted mode
+	ready    chan struct{} // a task was pushed; wakes one parked worker
+	done     chan struct{} // closed by closeQueues
+	parked   int32         // workers waiting for ready
+	overflow *overflow[T]  // nil unless in overflow mode
+}
+
+// newShardQueue creates the queues of a shard with the queue factory or for
+// a queue kind other than QueueChan, or unbounded ones in overflow mode
+func (wp *WorkerPool[T]) newShardQueue() *shardQueue[T] {
+	kind := wp.queueKind
+	newQueue := wp.queueFactory
+	if wp.overflow != nil {
+		kind, newQueue = QueueUnbounded, nil
+	}
+	if newQueue == nil {
+		newQueue = func(capacity int) TaskQueue[QueuedTask[T]] {
+			return newTaskQueue[T](kind, capacity)
+		}
+	}
+
+	numQueues := 1
+	if wp.priorityMode != PriorityOff {
+		numQueues = numLanes
+	}
+
+	sq := &shardQueue[T]{
+		lanes:    make([]TaskQueue[QueuedTask[T]], numQueues),
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		overflow: wp.overflow,
+	}
+	for i := range sq.lanes {
+		sq.lanes[i] = newQueue(wp.queueSize)
+	}
+	if wp.priorityMode == PriorityWeighted {
+		sq.schedule = weightedSchedule(wp.priorityWeights)
+	}
+
+	return sq
+}
+
+func (sq *shardQueue[T]) lane(lane int) TaskQueue[QueuedTask[T]] {
+	if len(sq.lanes) == 1 {
+		return sq.lanes[0]
+	}
+
+	return sq.lanes[lane]
+}
+
+// push adds a task to a lane and wakes a parked worker. In overflow mode,
+// the task must fit into the pool's limits.
+func (sq *shardQueue[T]) push(lane int, qt QueuedTask[T]) bool {
+	if sq.overflow != nil && !sq.overflow.reserve(&qt) {
+		return false
+	}
+	if !sq.lane(lane).Push(qt) {
+		if sq.overflow != nil {
+			sq.overflow.release(qt)
+		}
+		return false
+	}
+	sq.signal()
+
+	return true
+}
+
+func (sq *shardQueue[T]) signal() {
+	select {
+	case sq.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (sq *shardQueue[T]) len() int {
+	n := 0
+	for _, queue := range sq.lanes {
+		n += queue.Len()
+	}
+
+	return n
+}
+
+// backlog returns the number of queued tasks minus the parked workers that
+// are about to take them
+func (sq *shardQueue[T]) backlog() int {
+	return sq.len() - int(atomic.LoadInt32(&sq.parked))
+}
+
+func (sq *shardQueue[T]) poll(turn *int) (qt QueuedTask[T], ok bool, closed bool) {
+	// Check for the close first: no task is pushed after it, so empty
+	// queues are final then.
+	select {
+	case <-sq.done:
+		closed = true
+	default:
+	}
+
+	first := 0
+	if sq.schedule != nil {
+		first = int(sq.schedule[*turn%len(sq.schedule)])
+		*turn++
+	}
+
+	for i := -1; i < len(sq.lanes); i++ {
+		lane := i
+		if i < 0 {
+			lane = first
+		} else if i == first {
+			continue
+		}
+
+		if qt, ok = sq.lanes[lane].Pop(); ok {
+			if sq.overflow != nil {
+				sq.overflow.release(qt)
+			}
+			// The ready signal is coalesced, so pass it on while tasks are
+			// left for other parked workers.
+			if sq.len() > 0 {
+				sq.signal()
+			}
+			return qt, true, false
+		}
+	}
+
+	return qt, false, closed
+}
+
+func (sq *shardQueue[T]) wait(timeout <-chan time.Time, wake <-chan struct{}) (qt QueuedTask[T], ok bool, timedOut bool) {
+	turn := 0
+	for {
+		var closed bool
+		if qt, ok, closed = sq.poll(&turn); ok || closed {
+			return qt, ok, false
+		}
+
+		// A worker that finds the queue empty after ready fired lost the
+		// task to another one and parks again.
+		woken := false
+		atomic.AddInt32(&sq.parked, 1)
+		select {
+		case <-sq.ready:
+		case <-sq.done:
+		case <-wake:
+			woken = true
+		case <-timeout:
+			timedOut = true
+		}
+		atomic.AddInt32(&sq.parked, -1)
+
+		if woken || timedOut {
+			return qt, false, timedOut
+		}
+	}
+}
+
+// ChanQueue is a TaskQueue backed by a buffered channel.
+type ChanQueue[E any] struct {
+	ch chan E
+}
+
+// Creates a new channel queue with the given capacity
+func NewChanQueue[E any](capacity int) *ChanQueue[E] {
+	return &ChanQueue[E]{ch: make(chan E, capacity)}
+}
+
+func (q *ChanQueue[E]) Push(elem E) bool {
+	select {
+	case q.ch <- elem:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *ChanQueue[E]) Pop() (elem E, ok bool) {
+	select {
+	case elem = <-q.ch:
+		return elem, true
+	default:
+		return elem, false
+	}
+}
+
+func (q *ChanQueue[E]) Len() int {
+	return len(q.ch)
+}
+
+// RingQueue is a lock-free bounded multi-producer multi-consumer queue
+// (Dmitry Vyukov's ring buffer with per-cell sequence numbers).
+type RingQueue[E any] struct {
+	_     [64]byte
+	head  uint64 // next position to pop
+	_     [56]byte
+	tail  uint64 // next position to push
+	_     [56]byte
+	mask  uint64
+	cells []ringCell[E]
+}
+
+type ringCell[E any] struct {
+	seq  uint64
+	elem E
+}
+
+// Creates a new ring queue; the capacity is rounded up to a power of two
+func NewRingQueue[E any](capacity int) *RingQueue[E] {
+	size := 1
+	for size < capacity {
+		size <<= 1
+	}
+
+	q := &RingQueue[E]{
+		mask:  uint64(size - 1),
+		cells: make([]ringCell[E], size),
+	}
+	for i := range q.cells {
+		q.cells[i].seq = uint64(i)
+	}
+
+	return q
+}
+
+func (q *RingQueue[E]) Push(elem E) bool {
+	pos := atomic.LoadUint64(&q.tail)
+	for {
+		cell := &q.cells[pos&q.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&q.tail, pos, pos+1) {
+				cell.elem = elem
+				atomic.StoreUint64(&cell.seq, pos+1)
+				return true
+			}
+		case diff < 0:
+			// the cell still holds the element of the previous round
+			return false
+		}
+		pos = atomic.LoadUint64(&q.tail)
+	}
+}
+
+func (q *RingQueue[E]) Pop() (elem E, ok bool) {
+	pos := atomic.LoadUint64(&q.head)
+	for {
+		cell := &q.cells[pos&q.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		switch diff := int64(seq - (pos + 1)); {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&q.head, pos, pos+1) {
+				elem = cell.elem
+				var zero E
+				cell.elem = zero
+				atomic.StoreUint64(&cell.seq, pos+q.mask+1)
+				return elem, true
+			}
+		case diff < 0:
+			// the cell has not been written in this round
+			return elem, false
+		}
+		pos = atomic.LoadUint64(&q.head)
+	}
+}
+
+func (q *RingQueue[E]) Len() int {
+	head := atomic.LoadUint64(&q.head)
+	tail := atomic.LoadUint64(&q.tail)
+	if tail < head {
+		return 0
+	}
+
+	return int(tail - head)
+}
+
+// number of elements per segment of an UnboundedQueue
+const queueSegmentSize = 256
+
+// UnboundedQueue is a FIFO queue of linked fixed-size segments that grows
+// without limit. Drained segments are kept for reuse, one at a time.
+type UnboundedQueue[E any] struct {
+	mutex   sync.Mutex
+	head    *queueSegment[E]
+	tail    *queueSegment[E]
+	headPos int
+	tailPos int
+	spare   *queueSegment[E]
+	len     int64
+}
+
+type queueSegment[E any] struct {
+	elems [queueSegmentSize]E
+	next  *queueSegment[E]
+}
+
+// Creates a new unbounded queue
+func NewUnboundedQueue[E any]() *UnboundedQueue[E] {
+	segment := &queueSegment[E]{}
+
+	return &UnboundedQueue[E]{head: segment, tail: segment}
+}
+
+func (q *UnboundedQueue[E]) Push(elem E) bool {
+	q.mutex.Lock()
+	if q.tailPos == queueSegmentSize {
+		segment := q.spare
+		if segment != nil {
+			q.spare = nil
+		} else {
+			segment = &queueSegment[E]{}
+		}
+		q.tail.next = segment
+		q.tail = segment
+		q.tailPos = 0
+	}
+	q.tail.elems[q.tailPos] = elem
+	q.tailPos++
+	atomic.AddInt64(&q.len, 1)
+	q.mutex.Unlock()
+
+	return true
+}
+
+func (q *UnboundedQueue[E]) Pop() (elem E, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.len == 0 {
+		return elem, false
+	}
+
+	var zero E
+	elem = q.head.elems[q.headPos]
+	q.head.elems[q.headPos] = zero
+	q.headPos++
+	atomic.AddInt64(&q.len, -1)
+
+	// The last element is always in the tail segment.
+	if q.len == 0 {
+		q.headPos, q.tailPos = 0, 0
+	} else if q.headPos == queueSegmentSize {
+		drained := q.head
+		q.head = drained.next
+		q.headPos = 0
+		drained.next = nil
+		q.spare = drained
+	}
+
+	return elem, true
+}
+
+func (q *UnboundedQueue[E]) Len() int {
+	return int(atomic.LoadInt64(&q.len))
+}
+
+// StackQueue is a bounded LIFO stack: Pop returns the most recently pushed
+// element.
+type StackQueue[E any] struct {
+	mutex sync.Mutex
+	elems []E
+	len   int64
+}
+
+// Creates a new stack with the given capacity
+func NewStackQueue[E any](capacity int) *StackQueue[E] {
+	return &StackQueue[E]{elems: make([]E, 0, capacity)}
+}
+
+func (q *StackQueue[E]) Push(elem E) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.elems) == cap(q.elems) {
+		return false
+	}
+	q.elems = append(q.elems, elem)
+	atomic.AddInt64(&q.len, 1)
+
+	return true
+}
+
+func (q *StackQueue[E]) Pop() (elem E, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	n := len(q.elems)
+	if n == 0 {
+		return elem, false
+	}
+	elem = q.elems[n-1]
+	var zero E
+	q.elems[n-1] = zero
+	q.elems = q.elems[:n-1]
+	atomic.AddInt64(&q.len, -1)
+
+	return elem, true
+}
+
+func (q *StackQueue[E]) Len() int {
+	return int(atomic.LoadInt64(&q.len))
+}
@@ -0,0 +1,298 @@
+package ultrapool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTaskQueues(t *testing.T) {
+	tests := []struct {
+		name     string
+		queue    TaskQueue[int]
+		capacity int // -1 for unbounded
+		lifo     bool
+	}{
+		{"chan", NewChanQueue[int](20), 20, false},
+		{"ring", NewRingQueue[int](20), 32, false},
+		{"unbounded", NewUnboundedQueue[int](), -1, false},
+		{"stack", NewStackQueue[int](20), 20, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.queue
+			if _, ok := q.Pop(); ok {
+				t.Fatal("Pop on an empty queue succeeded")
+			}
+
+			// Run several rounds, so that ring and segments wrap around.
+			n := tt.capacity
+			if n < 0 {
+				n = 3*queueSegmentSize + 7
+			}
+			for round := 0; round < 3; round++ {
+				for i := 0; i < n; i++ {
+					if !q.Push(i) {
+						t.Fatalf("round %d: Push(%d) failed", round, i)
+					}
+				}
+				if tt.capacity >= 0 && q.Push(n) {
+					t.Errorf("round %d: Push on a full queue succeeded", round)
+				}
+				if got := q.Len(); got != n {
+					t.Errorf("round %d: Len: got %d, want %d", round, got, n)
+				}
+
+				for i := 0; i < n; i++ {
+					want := i
+					if tt.lifo {
+						want = n - 1 - i
+					}
+					got, ok := q.Pop()
+					if !ok || got != want {
+						t.Fatalf("round %d: Pop: got %d, %v, want %d, true", round, got, ok, want)
+					}
+				}
+				if _, ok := q.Pop(); ok {
+					t.Fatalf("round %d: Pop on a drained queue succeeded", round)
+				}
+				if got := q.Len(); got != 0 {
+					t.Errorf("round %d: Len after draining: got %d, want 0", round, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTaskQueuesConcurrent(t *testing.T) {
+	const numProducers = 4
+	const numConsumers = 4
+	const perProducer = 5000
+
+	tests := []struct {
+		name  string
+		queue TaskQueue[int]
+	}{
+		{"chan", NewChanQueue[int](64)},
+		{"ring", NewRingQueue[int](64)},
+		{"unbounded", NewUnboundedQueue[int]()},
+		{"stack", NewStackQueue[int](64)},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.queue
+			var seen [numProducers * perProducer]int32
+			var popped int64
+			var wg sync.WaitGroup
+
+			for p := 0; p < numProducers; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					for i := 0; i < perProducer; i++ {
+						for !q.Push(p*perProducer + i) {
+							time.Sleep(time.Microsecond)
+						}
+					}
+				}(p)
+			}
+			for c := 0; c < numConsumers; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for atomic.LoadInt64(&popped) < numProducers*perProducer {
+						elem, ok := q.Pop()
+						if !ok {
+							time.Sleep(time.Microsecond)
+							continue
+						}
+						atomic.AddInt32(&seen[elem], 1)
+						atomic.AddInt64(&popped, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			for elem, n := range seen {
+				if n != 1 {
+					t.Fatalf("element %d popped %d times, want 1", elem, n)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueKinds(t *testing.T) {
+	const numTasks = 5000
+
+	for _, kind := range []QueueKind{QueueChan, QueueRing, QueueUnbounded, QueueStack} {
+		for _, mode := range []struct {
+			name string
+			mode PriorityMode
+		}{{"fifo", PriorityOff}, {"weighted", PriorityWeighted}} {
+			kind, mode := kind, mode
+			t.Run(kind.String()+"/"+mode.name, func(t *testing.T) {
+				var ran int64
+				wp := NewWorkerPool(func(task int) {
+					atomic.AddInt64(&ran, 1)
+				})
+				wp.SetNumShards(2)
+				wp.SetShardMaxWorkers(8)
+				wp.SetIdleWorkerLifetime(10 * time.Millisecond)
+				wp.SetQueueKind(kind)
+				wp.SetPriorityMode(mode.mode)
+				wp.Start()
+
+				priorities := []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+				for i := 0; i < numTasks; i++ {
+					if err := wp.AddTaskPriority(priorities[i%3], i); err != nil {
+						t.Fatalf("AddTaskPriority(%d): %v", i, err)
+					}
+					if i%500 == 0 {
+						// let workers park and retire in between
+						time.Sleep(time.Millisecond)
+					}
+				}
+				wp.Wait()
+				if got := atomic.LoadInt64(&ran); got != numTasks {
+					t.Errorf("tasks run before Stop: got %d, want %d", got, numTasks)
+				}
+
+				for i := 0; i < numTasks; i++ {
+					_ = wp.AddTask(i)
+				}
+				wp.StopAndWait()
+				if got := wp.Stats().Completed; got != uint64(atomic.LoadInt64(&ran)) {
+					t.Errorf("completed tasks: got %d, want %d", got, ran)
+				}
+				if err := wp.AddTask(0); err != ErrPoolStopped {
+					t.Errorf("AddTask on a stopped pool: got %v, want ErrPoolStopped", err)
+				}
+			})
+		}
+	}
+}
+
+func TestQueueKindOverload(t *testing.T) {
+	const queueSize = 16
+
+	tests := []struct {
+		kind     QueueKind
+		capacity int // -1 for unbounded
+	}{
+		{QueueChan, queueSize},
+		{QueueRing, queueSize},
+		{QueueUnbounded, -1},
+		{QueueStack, queueSize},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.kind.String(), func(t *testing.T) {
+			order := make(chan int, 4*queueSize)
+			wp, _, releaseAll := engageBlockedPoolWith(t, 1, queueSize, time.Hour, func(wp *WorkerPool[int]) {
+				wp.SetQueueKind(tt.kind)
+				wp.SetHooks(&Hooks[int]{
+					OnTaskStart: func(shard int, task int) {
+						if task >= 0 {
+							order <- task
+						}
+					},
+				})
+			})
+			defer releaseAll()
+
+			n := 4 * queueSize
+			if tt.capacity >= 0 {
+				n = tt.capacity
+			}
+			for i := 0; i < n; i++ {
+				if err := wp.AddTask(i); err != nil {
+					t.Fatalf("AddTask(%d): %v", i, err)
+				}
+			}
+			if tt.capacity >= 0 {
+				if err := wp.AddTask(n); err != ErrPoolOverload {
+					t.Errorf("AddTask on a full queue: got %v, want ErrPoolOverload", err)
+				}
+			}
+			if got := wp.Stats().QueueLen; got != n {
+				t.Errorf("queue length: got %d, want %d", got, n)
+			}
+
+			releaseAll()
+			wp.StopAndWait()
+			close(order)
+
+			// The single worker runs the queued tasks in queue order.
+			i := 0
+			for task := range order {
+				want := i
+				if tt.kind == QueueStack {
+					want = n - 1 - i
+				}
+				if task != want {
+					t.Fatalf("task #%d: got %d, want %d", i, task, want)
+				}
+				i++
+			}
+			if i != n {
+				t.Errorf("tasks run: got %d, want %d", i, n)
+			}
+		})
+	}
+}
+
+// countingQueue is a custom TaskQueue that counts its pushes.
+type countingQueue[E any] struct {
+	TaskQueue[E]
+	pushes *int64
+}
+
+func (cq countingQueue[E]) Push(elem E) bool {
+	atomic.AddInt64(cq.pushes, 1)
+	return cq.TaskQueue.Push(elem)
+}
+
+func TestQueueFactory(t *testing.T) {
+	const numTasks = 1000
+
+	var queues, pushes, ran int64
+	wp := NewWorkerPool(func(task int) {
+		atomic.AddInt64(&ran, 1)
+	})
+	wp.SetNumShards(2)
+	wp.SetQueueSize(32)
+	wp.SetPriorityMode(PriorityStrict)
+	wp.SetQueueFactory(func(capacity int) TaskQueue[QueuedTask[int]] {
+		if capacity != 32 {
+			t.Errorf("queue capacity: got %d, want 32", capacity)
+		}
+		atomic.AddInt64(&queues, 1)
+		return countingQueue[QueuedTask[int]]{NewStackQueue[QueuedTask[int]](capacity), &pushes}
+	})
+	wp.Start()
+
+	for i := 0; i < numTasks; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.StopAndWait()
+
+	// one queue per priority lane and shard
+	if got := atomic.LoadInt64(&queues); got != 2*numLanes {
+		t.Errorf("queues created: got %d, want %d", got, 2*numLanes)
+	}
+	if got := atomic.LoadInt64(&pushes); got < numTasks {
+		t.Errorf("pushes into the custom queues: got %d, want >= %d", got, numTasks)
+	}
+	if got := atomic.LoadInt64(&ran); got != numTasks {
+		t.Errorf("tasks run: got %d, want %d", got, numTasks)
+	}
+}
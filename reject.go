@@ -0,0 +1,303 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// RejectionAction selects what AddTask does with a task whose shard queue
+// is full.
+type RejectionAction int
+
+const (
+	// Return ErrPoolOverload (the default).
+	RejectAbort RejectionAction = iota
+	// Run the task on the goroutine calling AddTask, which slows the
+	// producer down to the pace of the pool. Not available in key
+	// serialization mode, where it acts like RejectAbort.
+	RejectCallerRuns
+	// Evict the task the shard's workers would take next from the queue (the
+	// oldest one, unless the queue kind is QueueStack) to make room. Not
+	// available in key serialization mode, where it acts like RejectAbort.
+	RejectDropOldest
+	// Drop the task; AddTask returns nil.
+	RejectDiscard
+	// Block until the task is accepted, up to RejectionPolicy.Timeout.
+	RejectBlock
+	// Hand the task to RejectionPolicy.Handler. Not available in key
+	// serialization mode, where it acts like RejectAbort, as the handler
+	// would run the task out of order.
+	RejectCustom
+)
+
+func (a RejectionAction) String() string {
+	switch a {
+	case RejectAbort:
+		return "abort"
+	case RejectCallerRuns:
+		return "caller-runs"
+	case RejectDropOldest:
+		return "drop-oldest"
+	case RejectDiscard:
+		return "discard"
+	case RejectBlock:
+		return "block"
+	case RejectCustom:
+		return "custom"
+	}
+
+	return fmt.Sprintf("RejectionAction(%d)", int(a))
+}
+
+// RejectionPolicy configures what happens to tasks added with AddTask,
+// AddTaskPriority, AddTaskKeyed and AddTasks that don't fit into their
+// shard's queue. The blocking variants of these wait for room anyway, and
+// delayed tasks follow their DelayPolicy.
+type RejectionPolicy[T any] struct {
+	Action RejectionAction
+
+	// How long RejectBlock waits before AddTask gives up with
+	// ErrPoolOverload; 0 means until the task is accepted or the pool is
+	// stopped.
+	Timeout time.Duration
+
+	// Called with the rejected task with RejectCustom; AddTask returns its
+	// result.
+	Handler func(task T) error
+
+	// Called with every task dropped by RejectDropOldest or RejectDiscard,
+	// which Stats counts as Discarded. May be nil.
+	OnDiscard func(task T)
+}
+
+// Sets the policy for tasks that don't fit into their shard's queue. With
+// RejectCallerRuns, the task runs without task hooks and timing, in pools
+// with worker state (see NewWorkerPoolWithState) on a state of its own for
+// shard -1. An unknown action, or RejectCustom without a handler, resets the
+// policy to RejectAbort. Must be called before Start.
+func (wp *WorkerPool[T]) SetRejectionPolicy(policy RejectionPolicy[T]) {
+	wp.checkMutable("SetRejectionPolicy")
+	if policy.Action < RejectAbort || policy.Action > RejectCustom ||
+		(policy.Action == RejectCustom && policy.Handler == nil) {
+		policy.Action = RejectAbort
+	}
+	if policy.Timeout < 0 {
+		policy.Timeout = 0
+	}
+	wp.rejection = policy
+}
+
+// submit adds a task like addTask and applies the rejection policy if its
+// shard's queue is full. Only a task that ends up refused with
+// ErrPoolOverload counts as rejected.
+func (wp *WorkerPool[T]) submit(lane int, task T) error {
+	abort := wp.rejection.Action == RejectAbort
+	err := wp.addTask(lane, task, abort)
+	if err != ErrPoolOverload || abort {
+		return err
+	}
+
+	return wp.reject(lane, task, func() error {
+		return wp.addTask(lane, task, false)
+	}, func(shards []*poolShard[T]) *poolShard[T] {
+		return wp.shardOf(shards, task)
+	})
+}
+
+// submitKeyed is submit for a task with an explicit key
+func (wp *WorkerPool[T]) submitKeyed(lane int, key uint64, task T) error {
+	abort := wp.rejection.Action == RejectAbort
+	err := wp.addTaskKeyed(lane, key, task, abort)
+	if err != ErrPoolOverload || abort {
+		return err
+	}
+
+	return wp.reject(lane, task, func() error {
+		return wp.addTaskKeyed(lane, key, task, false)
+	}, func(shards []*poolShard[T]) *poolShard[T] {
+		return shards[keyHash(key)%uint64(len(shards))]
+	})
+}
+
+// shardOf returns the shard a task added with addTask goes to: the one of
+// its key, or a random one
+func (wp *WorkerPool[T]) shardOf(shards []*poolShard[T], task T) *poolShard[T] {
+	if wp.keyFunc != nil {
+		return shards[keyHash(wp.keyFunc(task))%uint64(len(shards))]
+	}
+
+	return shards[randInt()%len(shards)]
+}
+
+// reject applies the rejection policy to a task that was refused with
+// ErrPoolOverload (without counting the rejection). add retries the
+// submission without counting either, shardOf picks the shard the task goes
+// to (from the current shard table). If the task is refused in the end, it
+// counts as rejected on that shard.
+func (wp *WorkerPool[T]) reject(lane int, task T, add func() error, shardOf func(shards []*poolShard[T]) *poolShard[T]) error {
+	if wp.rejection.Action == RejectDropOldest && wp.keys == nil {
+		// dispatchDropOldest counts the rejection itself.
+		for {
+			err := shardOf(wp.shards.Load().shards).dispatchDropOldest(lane, task)
+			if err != errShardRemoved {
+				return err
+			}
+		}
+	}
+
+	err := wp.applyRejection(task, add)
+	if err == ErrPoolOverload {
+		atomic.AddUint64(&shardOf(wp.shards.Load().shards).rejected, 1)
+	}
+
+	return err
+}
+
+// applyRejection applies all rejection actions but RejectDropOldest
+func (wp *WorkerPool[T]) applyRejection(task T, add func() error) error {
+	policy := &wp.rejection
+
+	// Running or evicting a task outside of its key's backlog would break the
+	// per-key order.
+	if wp.keys != nil && (policy.Action == RejectCallerRuns ||
+		policy.Action == RejectDropOldest || policy.Action == RejectCustom) {
+		return ErrPoolOverload
+	}
+
+	switch policy.Action {
+	case RejectCallerRuns:
+		wp.runInCaller(task)
+		return nil
+
+	case RejectDiscard:
+		wp.discard(task)
+		return nil
+
+	case RejectBlock:
+		ctx := context.Background()
+		if policy.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, policy.Timeout)
+			defer cancel()
+		}
+		err := wp.blockOnOverload(ctx, add)
+		if err == context.DeadlineExceeded {
+			err = ErrPoolOverload
+		}
+		return err
+
+	case RejectCustom:
+		return policy.Handler(task)
+	}
+
+	return ErrPoolOverload
+}
+
+// runInCaller runs a task on the calling goroutine
+func (wp *WorkerPool[T]) runInCaller(task T) {
+	handler, release := wp.handlerFunc, func() {}
+	if wp.newWorker != nil {
+		handler, release = wp.newWorker(-1)
+	}
+	if wp.batching != nil {
+		handlerFunc := wp.batching.handlerFunc
+		handler = func(task T) {
+			handlerFunc([]T{task})
+		}
+	}
+
+	wp.execute(handler, task)
+	release()
+}
+
+// discard counts and reports a dropped task
+func (wp *WorkerPool[T]) discard(task T) {
+	atomic.AddUint64(&wp.discarded, 1)
+	if wp.rejection.OnDiscard != nil {
+		wp.rejection.OnDiscard(task)
+	}
+}
+
+// dispatchDropOldest enqueues a task into the given priority lane like
+// dispatch, evicting queued tasks of the lane while it is full. Returns
+// ErrPoolOverload if there is nothing to evict (in overflow mode, other
+// shards may hold the queued tasks).
+func (shard *poolShard[T]) dispatchDropOldest(lane int, task T) error {
+	wp := shard.wp
+	queue := shard.laneQueue(lane)
+
+	shard.tqLock.RLock()
+
+	if atomic.LoadInt32(&wp.stopped) != 0 {
+		shard.tqLock.RUnlock()
+		return ErrPoolStopped
+	}
+	if shard.removed {
+		shard.tqLock.RUnlock()
+		return errShardRemoved
+	}
+
+	qt := QueuedTask[T]{task: task}
+	if shard.timing != nil {
+		qt.meta = &taskMeta{enqueuedAt: nanotime()}
+	}
+	atomic.AddUint64(&shard.submitted, 1)
+
+	sent := true
+	var evicted []QueuedTask[T]
+	for !shard.send(queue, lane, qt) {
+		old, ok := shard.evict(queue, lane)
+		if !ok {
+			atomic.AddUint64(&shard.submitted, ^uint64(0))
+			atomic.AddUint64(&shard.rejected, 1)
+			sent = false
+			break
+		}
+		// The evicted task leaves without completing.
+		atomic.AddUint64(&shard.submitted, ^uint64(0))
+		evicted = append(evicted, old)
+	}
+	if shard.hasBacklog(queue) {
+		shard.trySpawnWorker()
+	}
+	submitted := atomic.LoadUint64(&shard.submitted)
+	shard.tqLock.RUnlock()
+
+	for _, old := range evicted {
+		wp.discard(old.task)
+		if group := old.group(); group != nil {
+			group.done(ErrPoolOverload)
+		}
+	}
+	shard.checkIdle(submitted, atomic.LoadUint64(&shard.completed))
+	if !sent {
+		return ErrPoolOverload
+	}
+
+	return nil
+}
+
+// evict takes the task the shard's workers would take next off the given
+// lane (whose channel is queue)
+func (shard *poolShard[T]) evict(queue chan QueuedTask[T], lane int) (qt QueuedTask[T], ok bool) {
+	if shard.queue != nil {
+		qt, ok = shard.queue.lane(lane).Pop()
+		if ok && shard.queue.overflow != nil {
+			shard.queue.overflow.release(qt)
+		}
+		return qt, ok
+	}
+
+	// The queue isn't closed while we hold the RLock.
+	select {
+	case qt, ok = <-queue:
+	default:
+	}
+
+	return qt, ok
+}
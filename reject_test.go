@@ -0,0 +1,298 @@
+package ultrapool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rejectionPool returns a pool from engageBlockedPoolWith whose queue is
+// filled with the tasks 0 to queueSize-1, along with a channel that receives
+// every task that starts, and the tasks passed to OnDiscard.
+func rejectionPool(t *testing.T, queueSize int, policy RejectionPolicy[int]) (*WorkerPool[int], chan int, func() []int, func()) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var discarded []int
+	policy.OnDiscard = func(task int) {
+		mutex.Lock()
+		discarded = append(discarded, task)
+		mutex.Unlock()
+	}
+
+	started := make(chan int, 4*queueSize)
+	wp, _, releaseAll := engageBlockedPoolWith(t, 1, queueSize, time.Hour, func(wp *WorkerPool[int]) {
+		wp.SetRejectionPolicy(policy)
+		wp.SetHooks(&Hooks[int]{
+			OnTaskStart: func(shard int, task int) {
+				if task >= 0 {
+					started <- task
+				}
+			},
+		})
+	})
+	for i := 0; i < queueSize; i++ {
+		if err := wp.AddTask(i); err != nil {
+			releaseAll()
+			t.Fatalf("AddTask(%d): %v", i, err)
+		}
+	}
+
+	getDiscarded := func() []int {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return append([]int(nil), discarded...)
+	}
+
+	return wp, started, getDiscarded, releaseAll
+}
+
+func TestRejectionPolicy(t *testing.T) {
+	const queueSize = 16
+	errCustom := errors.New("custom")
+
+	var handled []int
+	tests := []struct {
+		name          string
+		policy        RejectionPolicy[int]
+		wantErr       error
+		wantDiscarded []int
+		wantFirst     int // first task to run
+	}{
+		{"abort", RejectionPolicy[int]{}, ErrPoolOverload, nil, 0},
+		{"discard", RejectionPolicy[int]{Action: RejectDiscard}, nil, []int{100}, 0},
+		{"drop oldest", RejectionPolicy[int]{Action: RejectDropOldest}, nil, []int{0}, 1},
+		{"custom", RejectionPolicy[int]{Action: RejectCustom, Handler: func(task int) error {
+			handled = append(handled, task)
+			return errCustom
+		}}, errCustom, nil, 0},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			handled = nil
+			wp, started, discarded, releaseAll := rejectionPool(t, queueSize, tt.policy)
+			defer releaseAll()
+
+			if err := wp.AddTask(100); err != tt.wantErr {
+				t.Errorf("AddTask on a full queue: got %v, want %v", err, tt.wantErr)
+			}
+			got := discarded()
+			if len(got) != len(tt.wantDiscarded) || (len(got) > 0 && got[0] != tt.wantDiscarded[0]) {
+				t.Errorf("discarded tasks: got %v, want %v", got, tt.wantDiscarded)
+			}
+			if stats := wp.Stats(); stats.Discarded != uint64(len(tt.wantDiscarded)) {
+				t.Errorf("Discarded: got %d, want %d", stats.Discarded, len(tt.wantDiscarded))
+			}
+			if tt.policy.Action == RejectCustom && (len(handled) != 1 || handled[0] != 100) {
+				t.Errorf("custom handler calls: got %v, want [100]", handled)
+			}
+
+			releaseAll()
+			wp.StopAndWait()
+			close(started)
+
+			var run []int
+			for task := range started {
+				run = append(run, task)
+			}
+			if len(run) != queueSize || run[0] != tt.wantFirst {
+				t.Fatalf("tasks run: got %v, want %d tasks starting with %d", run, queueSize, tt.wantFirst)
+			}
+			if tt.policy.Action == RejectDropOldest && run[len(run)-1] != 100 {
+				t.Errorf("last task run: got %d, want 100", run[len(run)-1])
+			}
+		})
+	}
+}
+
+func TestRejectionCallerRuns(t *testing.T) {
+	const queueSize = 16
+
+	wp, _, _, releaseAll := rejectionPool(t, queueSize, RejectionPolicy[int]{Action: RejectCallerRuns})
+	defer releaseAll()
+
+	// The handler blocks until release, now on the calling goroutine.
+	done := make(chan error)
+	go func() {
+		done <- wp.AddTask(100)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("AddTask returned %v before the handler", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseAll()
+	if err := <-done; err != nil {
+		t.Errorf("AddTask: %v", err)
+	}
+	wp.StopAndWait()
+
+	// The caller's run doesn't count as completed by a worker.
+	if got := wp.Stats().Completed; got != queueSize+1 {
+		t.Errorf("completed tasks: got %d, want %d", got, queueSize+1)
+	}
+}
+
+func TestRejectionBlock(t *testing.T) {
+	const queueSize = 16
+	const timeout = 30 * time.Millisecond
+
+	wp, started, _, releaseAll := rejectionPool(t, queueSize, RejectionPolicy[int]{Action: RejectBlock, Timeout: timeout})
+	defer releaseAll()
+
+	start := time.Now()
+	if err := wp.AddTask(100); err != ErrPoolOverload {
+		t.Errorf("AddTask on a full queue: got %v, want ErrPoolOverload", err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("AddTask gave up after %v, want >= %v", elapsed, timeout)
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		releaseAll()
+	}()
+	if err := wp.AddTask(101); err != nil {
+		t.Errorf("AddTask with room freed up meanwhile: %v", err)
+	}
+	if got := wp.Stats().Rejected; got != 1 {
+		t.Errorf("Stats.Rejected: got %d, want 1 (the task that timed out)", got)
+	}
+	wp.StopAndWait()
+	close(started)
+
+	n := 0
+	for task := range started {
+		if task == 100 {
+			t.Error("the task that timed out ran")
+		}
+		n++
+	}
+	if n != queueSize+1 {
+		t.Errorf("tasks run: got %d, want %d", n, queueSize+1)
+	}
+}
+
+func TestRejectionPolicyAddTasks(t *testing.T) {
+	const queueSize = 16
+
+	tests := []struct {
+		name          string
+		policy        RejectionPolicy[int]
+		keyed         bool
+		wantAccepted  int
+		wantErr       error
+		wantDiscarded uint64
+		wantRejected  uint64
+	}{
+		{"abort", RejectionPolicy[int]{}, false, 0, ErrPoolOverload, 0, 3},
+		{"discard", RejectionPolicy[int]{Action: RejectDiscard}, false, 3, nil, 3, 0},
+		{"discard keyed", RejectionPolicy[int]{Action: RejectDiscard}, true, 3, nil, 3, 0},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			wp, _, discarded, releaseAll := rejectionPool(t, queueSize, tt.policy)
+			defer releaseAll()
+			if tt.keyed {
+				// every task goes to the only shard
+				wp.keyFunc = func(task int) uint64 { return uint64(task) }
+			}
+
+			tasks := []int{100, 101, 102}
+			accepted, err := wp.AddTasks(tasks)
+			if accepted != tt.wantAccepted || err != tt.wantErr {
+				t.Errorf("AddTasks: got %d, %v, want %d, %v", accepted, err, tt.wantAccepted, tt.wantErr)
+			}
+			if got := discarded(); uint64(len(got)) != tt.wantDiscarded {
+				t.Errorf("discarded tasks: got %v, want %d", got, tt.wantDiscarded)
+			}
+
+			// A task counts as rejected once, and only if AddTasks refuses it.
+			stats := wp.Stats()
+			if stats.Rejected != tt.wantRejected || stats.Discarded != tt.wantDiscarded {
+				t.Errorf("stats: got rejected %d, discarded %d, want %d, %d",
+					stats.Rejected, stats.Discarded, tt.wantRejected, tt.wantDiscarded)
+			}
+
+			releaseAll()
+			wp.StopAndWait()
+		})
+	}
+}
+
+func TestRejectionPolicyKeySerialization(t *testing.T) {
+	const queueSize = 16
+
+	for _, action := range []RejectionAction{RejectCallerRuns, RejectDropOldest, RejectCustom} {
+		action := action
+		t.Run(action.String(), func(t *testing.T) {
+			release := make(chan struct{})
+			var running, overlaps int32
+			var mutex sync.Mutex
+			var order []int
+
+			handle := func(task int) {
+				if atomic.AddInt32(&running, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				mutex.Lock()
+				order = append(order, task)
+				mutex.Unlock()
+				if task == 0 {
+					<-release
+				}
+				atomic.AddInt32(&running, -1)
+			}
+
+			wp := NewWorkerPool(handle)
+			wp.SetNumShards(1)
+			wp.SetShardMaxWorkers(4)
+			wp.SetQueueSize(queueSize)
+			wp.SetKeyFunc(func(task int) uint64 { return 1 })
+			wp.SetKeySerialization(true)
+			wp.SetRejectionPolicy(RejectionPolicy[int]{Action: action, Handler: func(task int) error {
+				handle(task)
+				return nil
+			}})
+			wp.Start()
+
+			// Task 0 blocks; the next ones wait in the key's backlog until
+			// it is full.
+			if err := wp.AddTask(0); err != nil {
+				t.Fatalf("AddTask(0): %v", err)
+			}
+			for i := 1; i <= queueSize+1; i++ {
+				err := wp.AddTask(i)
+				if i <= queueSize && err != nil {
+					t.Errorf("AddTask(%d): %v", i, err)
+				}
+				if i > queueSize && err != ErrPoolOverload {
+					t.Errorf("AddTask(%d) on a full backlog: got %v, want ErrPoolOverload", i, err)
+				}
+			}
+
+			close(release)
+			wp.StopAndWait()
+
+			if n := atomic.LoadInt32(&overlaps); n != 0 {
+				t.Errorf("tasks with the same key overlapped %d times", n)
+			}
+			for i, task := range order {
+				if task != i {
+					t.Fatalf("execution order: got %v, want 0..%d", order, queueSize)
+				}
+			}
+			if len(order) != queueSize+1 {
+				t.Errorf("tasks run: got %d, want %d", len(order), queueSize+1)
+			}
+		})
+	}
+}
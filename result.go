@@ -0,0 +1,365 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTaskPanicked is delivered as a task's error when its handler panicked.
+var ErrTaskPanicked = errors.New("task panicked")
+
+// TaskHandlerFuncE is a task handler that returns a result and an error.
+type TaskHandlerFuncE[T, R any] func(task T) (R, error)
+
+// ResultFunc receives the result and error of a single task.
+type ResultFunc[R any] func(result R, err error)
+
+// ResultPool is a worker pool for handlers that return a result and an error.
+// It runs on the same sharded dispatch as WorkerPool (whose configuration and
+// lifecycle methods it forwards), and delivers each task's outcome to the
+// callback passed at submission or to a pooled Future, so no channel is
+// allocated per task.
+//
+// Every task must deliver its outcome, so ResultPool leaves out the
+// WorkerPool features that can drop an accepted task or hand it out of the
+// pool: rejection policies (SetRejectionPolicy) discard or evict tasks,
+// delayed tasks (SetDelayPolicy, AddTaskAfter) are dropped on overload or
+// Stop, and custom queues (SetQueueFactory) would hold the pool's internal
+// task type. AddTasks is left out as well, since a batch has no per-task
+// callback.
+type ResultPool[T, R any] struct {
+	pool        *WorkerPool[resultTask[T, R]]
+	handlerFunc TaskHandlerFuncE[T, R]
+	futures     sync.Pool
+}
+
+type resultTask[T, R any] struct {
+	task     T
+	callback ResultFunc[R]
+	future   *Future[R]
+	group    *taskGroup // set for tasks of a ResultGroup, which the pool accounts for
+}
+
+// Creates a new ResultPool with the given task handling function
+func NewResultPool[T, R any](handlerFunc TaskHandlerFuncE[T, R]) *ResultPool[T, R] {
+	rp := &ResultPool[T, R]{
+		handlerFunc: handlerFunc,
+	}
+	rp.pool = NewWorkerPool(rp.handle)
+
+	return rp
+}
+
+// Sets a handler that is called when a task handler panics. The task's
+// callback receives ErrTaskPanicked before the panic handler runs.
+func (rp *ResultPool[T, R]) SetPanicHandler(handler PanicHandlerFunc[T]) {
+	if handler == nil {
+		rp.pool.SetPanicHandler(nil)
+		return
+	}
+
+	rp.pool.SetPanicHandler(func(rt resultTask[T, R], recovered any, stack []byte) {
+		handler(rt.task, recovered, stack)
+	})
+}
+
+// Sets the maximum number of workers (see WorkerPool.SetMaxWorkers).
+func (rp *ResultPool[T, R]) SetMaxWorkers(n int) {
+	rp.pool.SetMaxWorkers(n)
+}
+
+// Sets the per-shard task queue capacity (see WorkerPool.SetQueueSize).
+func (rp *ResultPool[T, R]) SetQueueSize(size int) {
+	rp.pool.SetQueueSize(size)
+}
+
+// Sets the minimum number of workers per shard (see
+// WorkerPool.SetShardMinWorkers).
+func (rp *ResultPool[T, R]) SetShardMinWorkers(n int) {
+	rp.pool.SetShardMinWorkers(n)
+}
+
+// Sets the maximum number of workers per shard (see
+// WorkerPool.SetShardMaxWorkers).
+func (rp *ResultPool[T, R]) SetShardMaxWorkers(n int) {
+	rp.pool.SetShardMaxWorkers(n)
+}
+
+// Sets the number of shards (see WorkerPool.SetNumShards).
+func (rp *ResultPool[T, R]) SetNumShards(numShards int) {
+	rp.pool.SetNumShards(numShards)
+}
+
+// Sets the idle worker lifetime (see WorkerPool.SetIdleWorkerLifetime).
+func (rp *ResultPool[T, R]) SetIdleWorkerLifetime(d time.Duration) {
+	rp.pool.SetIdleWorkerLifetime(d)
+}
+
+// Enables timing mode (see WorkerPool.SetTaskTiming).
+func (rp *ResultPool[T, R]) SetTaskTiming(enabled bool) {
+	rp.pool.SetTaskTiming(enabled)
+}
+
+// Selects the queue implementation of the shards (see
+// WorkerPool.SetQueueKind).
+func (rp *ResultPool[T, R]) SetQueueKind(kind QueueKind) {
+	rp.pool.SetQueueKind(kind)
+}
+
+// Enables work stealing between shards (see WorkerPool.SetWorkStealing).
+func (rp *ResultPool[T, R]) SetWorkStealing(enabled bool) {
+	rp.pool.SetWorkStealing(enabled)
+}
+
+// Enables the background tuner (see WorkerPool.SetAutoTuning).
+func (rp *ResultPool[T, R]) SetAutoTuning(at *AutoTuning) {
+	rp.pool.SetAutoTuning(at)
+}
+
+// Selects how workers dequeue from the priority lanes (see
+// WorkerPool.SetPriorityMode).
+func (rp *ResultPool[T, R]) SetPriorityMode(mode PriorityMode) {
+	rp.pool.SetPriorityMode(mode)
+}
+
+// Sets the weights of the priority lanes (see
+// WorkerPool.SetPriorityWeights).
+func (rp *ResultPool[T, R]) SetPriorityWeights(high, normal, low int) {
+	rp.pool.SetPriorityWeights(high, normal, low)
+}
+
+// Sets a function that derives a key from each task (see
+// WorkerPool.SetKeyFunc).
+func (rp *ResultPool[T, R]) SetKeyFunc(fn KeyFunc[T]) {
+	if fn == nil {
+		rp.pool.SetKeyFunc(nil)
+		return
+	}
+
+	rp.pool.SetKeyFunc(func(rt resultTask[T, R]) uint64 {
+		return fn(rt.task)
+	})
+}
+
+// Enables key serialization (see WorkerPool.SetKeySerialization).
+func (rp *ResultPool[T, R]) SetKeySerialization(enabled bool) {
+	rp.pool.SetKeySerialization(enabled)
+}
+
+// Sets the lifecycle hooks (see WorkerPool.SetHooks).
+func (rp *ResultPool[T, R]) SetHooks(hooks *Hooks[T]) {
+	if hooks == nil {
+		rp.pool.SetHooks(nil)
+		return
+	}
+
+	h := &Hooks[resultTask[T, R]]{
+		OnWorkerStart: hooks.OnWorkerStart,
+		OnWorkerExit:  hooks.OnWorkerExit,
+	}
+	if onTaskStart := hooks.OnTaskStart; onTaskStart != nil {
+		h.OnTaskStart = func(shard int, rt resultTask[T, R]) {
+			onTaskStart(shard, rt.task)
+		}
+	}
+	if onTaskDone := hooks.OnTaskDone; onTaskDone != nil {
+		h.OnTaskDone = func(shard int, rt resultTask[T, R], duration time.Duration, recovered any) {
+			onTaskDone(shard, rt.task, duration, recovered)
+		}
+	}
+	rp.pool.SetHooks(h)
+}
+
+// Enables overflow mode (see WorkerPool.SetOverflowPolicy).
+func (rp *ResultPool[T, R]) SetOverflowPolicy(policy *OverflowPolicy[T]) {
+	if policy == nil {
+		rp.pool.SetOverflowPolicy(nil)
+		return
+	}
+
+	p := &OverflowPolicy[resultTask[T, R]]{
+		MaxTasks:        policy.MaxTasks,
+		MaxBytes:        policy.MaxBytes,
+		HighWatermark:   policy.HighWatermark,
+		LowWatermark:    policy.LowWatermark,
+		OnHighWatermark: policy.OnHighWatermark,
+		OnLowWatermark:  policy.OnLowWatermark,
+	}
+	if sizeFunc := policy.SizeFunc; sizeFunc != nil {
+		p.SizeFunc = func(rt resultTask[T, R]) int {
+			return sizeFunc(rt.task)
+		}
+	}
+	rp.pool.SetOverflowPolicy(p)
+}
+
+// Returns the current worker limits
+func (rp *ResultPool[T, R]) Config() Config {
+	return rp.pool.Config()
+}
+
+// Replaces the worker limits of a (possibly running) pool (see
+// WorkerPool.Reconfigure).
+func (rp *ResultPool[T, R]) Reconfigure(cfg Config) error {
+	return rp.pool.Reconfigure(cfg)
+}
+
+// Changes the number of shards of a (possibly running) pool (see
+// WorkerPool.ResizeShards).
+func (rp *ResultPool[T, R]) ResizeShards(n int) error {
+	return rp.pool.ResizeShards(n)
+}
+
+// Returns the number of currently spawned workers
+func (rp *ResultPool[T, R]) GetSpawnedWorkers() int {
+	return rp.pool.GetSpawnedWorkers()
+}
+
+// Returns the number of shards
+func (rp *ResultPool[T, R]) GetNumShards() int {
+	return rp.pool.GetNumShards()
+}
+
+// Returns a snapshot of the pool's gauges and counters (see WorkerPool.Stats)
+func (rp *ResultPool[T, R]) Stats() Stats {
+	return rp.pool.Stats()
+}
+
+// Starts the pool
+func (rp *ResultPool[T, R]) Start() {
+	rp.pool.Start()
+}
+
+// Blocks until every task submitted so far has finished executing
+func (rp *ResultPool[T, R]) Wait() {
+	rp.pool.Wait()
+}
+
+// Blocks until every task submitted so far has finished executing or ctx is
+// done (see WorkerPool.WaitContext)
+func (rp *ResultPool[T, R]) WaitContext(ctx context.Context) error {
+	return rp.pool.WaitContext(ctx)
+}
+
+// Stops the pool
+func (rp *ResultPool[T, R]) Stop() {
+	rp.pool.Stop()
+}
+
+// Returns a channel that is closed once the pool is stopped; nil before
+// Start
+func (rp *ResultPool[T, R]) Stopped() <-chan struct{} {
+	return rp.pool.Stopped()
+}
+
+// Stops the pool and blocks until all workers have exited
+func (rp *ResultPool[T, R]) StopAndWait() {
+	rp.pool.StopAndWait()
+}
+
+// Stops the pool and waits up to timeout for all workers to exit. Returns
+// true if all workers exited, false on timeout.
+func (rp *ResultPool[T, R]) StopWithTimeout(timeout time.Duration) bool {
+	return rp.pool.StopWithTimeout(timeout)
+}
+
+// Adds a new task; callback (may be nil) receives its result
+func (rp *ResultPool[T, R]) AddTask(task T, callback ResultFunc[R]) error {
+	return rp.pool.AddTask(resultTask[T, R]{task: task, callback: callback})
+}
+
+// Adds a new task unless ctx is already done; callback (may be nil) receives its result
+func (rp *ResultPool[T, R]) AddTaskContext(ctx context.Context, task T, callback ResultFunc[R]) error {
+	return rp.pool.AddTaskContext(ctx, resultTask[T, R]{task: task, callback: callback})
+}
+
+// Adds a new task and blocks until submitted; callback (may be nil) receives its result
+func (rp *ResultPool[T, R]) AddTaskWithBlocking(task T, callback ResultFunc[R]) error {
+	return rp.pool.AddTaskWithBlocking(resultTask[T, R]{task: task, callback: callback})
+}
+
+// Adds a new task and blocks until submitted, ctx is done or the pool is
+// stopped; callback (may be nil) receives its result
+func (rp *ResultPool[T, R]) AddTaskWithBlockingContext(ctx context.Context, task T, callback ResultFunc[R]) error {
+	return rp.pool.AddTaskWithBlockingContext(ctx, resultTask[T, R]{task: task, callback: callback})
+}
+
+// Adds a new task with the given priority (see WorkerPool.AddTaskPriority);
+// callback (may be nil) receives its result
+func (rp *ResultPool[T, R]) AddTaskPriority(priority Priority, task T, callback ResultFunc[R]) error {
+	return rp.pool.AddTaskPriority(priority, resultTask[T, R]{task: task, callback: callback})
+}
+
+// Adds a new task with the given priority and blocks until submitted, ctx is
+// done or the pool is stopped; callback (may be nil) receives its result
+func (rp *ResultPool[T, R]) AddTaskPriorityWithBlockingContext(ctx context.Context, priority Priority, task T, callback ResultFunc[R]) error {
+	return rp.pool.AddTaskPriorityWithBlockingContext(ctx, priority, resultTask[T, R]{task: task, callback: callback})
+}
+
+// Adds a new task to the shard the key hashes to (see
+// WorkerPool.AddTaskKeyed); callback (may be nil) receives its result
+func (rp *ResultPool[T, R]) AddTaskKeyed(key uint64, task T, callback ResultFunc[R]) error {
+	return rp.pool.AddTaskKeyed(key, resultTask[T, R]{task: task, callback: callback})
+}
+
+// Adds a new task and returns a Future for its result
+func (rp *ResultPool[T, R]) Submit(task T) (*Future[R], error) {
+	return rp.submit(task, rp.pool.AddTask)
+}
+
+// Adds a new task, blocks until submitted and returns a Future for its result
+func (rp *ResultPool[T, R]) SubmitWithBlocking(task T) (*Future[R], error) {
+	return rp.submit(task, rp.pool.AddTaskWithBlocking)
+}
+
+// Adds a new task, blocks until submitted, ctx is done or the pool is stopped,
+// and returns a Future for its result
+func (rp *ResultPool[T, R]) SubmitWithBlockingContext(ctx context.Context, task T) (*Future[R], error) {
+	return rp.submit(task, func(rt resultTask[T, R]) error {
+		return rp.pool.AddTaskWithBlockingContext(ctx, rt)
+	})
+}
+
+func (rp *ResultPool[T, R]) submit(task T, add func(rt resultTask[T, R]) error) (*Future[R], error) {
+	f := getFuture[R](&rp.futures)
+	if err := add(resultTask[T, R]{task: task, future: f}); err != nil {
+		f.discard()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// handle runs the task handler and delivers its outcome. If the handler
+// panics, the callback, future or group still receives ErrTaskPanicked before the panic
+// continues to the pool's panic handling.
+func (rp *ResultPool[T, R]) handle(rt resultTask[T, R]) {
+	delivered := false
+	defer func() {
+		if !delivered {
+			var zero R
+			rt.deliver(zero, ErrTaskPanicked)
+		}
+	}()
+
+	result, err := rp.handlerFunc(rt.task)
+	delivered = true
+	rt.deliver(result, err)
+}
+
+func (rt *resultTask[T, R]) deliver(result R, err error) {
+	if rt.future != nil {
+		rt.future.complete(result, err)
+	} else if rt.group != nil {
+		if err != nil {
+			rt.group.fail(err)
+		}
+	} else if rt.callback != nil {
+		rt.callback(result, err)
+	}
+}
@@ -0,0 +1,179 @@
+package ultrapool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResultPoolDeliversResults(t *testing.T) {
+	const numTasks = 200
+
+	errOdd := errors.New("odd")
+	rp := NewResultPool(func(task int) (int, error) {
+		if task%2 != 0 {
+			return 0, errOdd
+		}
+		return task * 2, nil
+	})
+	rp.SetNumShards(2)
+	rp.SetShardMaxWorkers(8)
+	rp.Start()
+	defer rp.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(numTasks)
+
+	results := make([]int, numTasks)
+	var failures int64
+
+	for i := 0; i < numTasks; i++ {
+		i := i
+		err := rp.AddTaskWithBlocking(i, func(result int, err error) {
+			defer wg.Done()
+			if err != nil {
+				if err != errOdd {
+					t.Errorf("task %d: unexpected error %v", i, err)
+				}
+				atomic.AddInt64(&failures, 1)
+				return
+			}
+			results[i] = result
+		})
+		if err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&failures); got != numTasks/2 {
+		t.Errorf("failures: got %d, want %d", got, numTasks/2)
+	}
+	for i := 0; i < numTasks; i += 2 {
+		if results[i] != i*2 {
+			t.Errorf("result of task %d: got %d, want %d", i, results[i], i*2)
+		}
+	}
+}
+
+func TestResultPoolPanicDeliversError(t *testing.T) {
+	rp := NewResultPool(func(task int) (string, error) {
+		panic("boom")
+	})
+	rp.SetNumShards(1)
+
+	var panicked int64
+	rp.SetPanicHandler(func(task int, recovered any, stack []byte) {
+		if task != 7 {
+			t.Errorf("panic handler task: got %d, want 7", task)
+		}
+		atomic.AddInt64(&panicked, 1)
+	})
+	rp.Start()
+
+	done := make(chan error, 1)
+	if err := rp.AddTask(7, func(result string, err error) {
+		done <- err
+	}); err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+
+	if err := <-done; err != ErrTaskPanicked {
+		t.Errorf("callback error: got %v, want ErrTaskPanicked", err)
+	}
+
+	rp.StopAndWait()
+
+	if got := atomic.LoadInt64(&panicked); got != 1 {
+		t.Errorf("panic handler calls: got %d, want 1", got)
+	}
+}
+
+func TestResultPoolNilCallback(t *testing.T) {
+	var handled int64
+
+	rp := NewResultPool(func(task int) (int, error) {
+		atomic.AddInt64(&handled, 1)
+		return task, nil
+	})
+	rp.SetNumShards(1)
+	rp.Start()
+
+	for i := 0; i < 10; i++ {
+		if err := rp.AddTaskWithBlocking(i, nil); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+
+	rp.StopAndWait()
+
+	if got := atomic.LoadInt64(&handled); got != 10 {
+		t.Errorf("handled tasks: got %d, want 10", got)
+	}
+}
+
+func TestResultPoolForwardsTaskFeatures(t *testing.T) {
+	const numTasks = 100
+	const numKeys = 4
+
+	rp := NewResultPool(func(task int) (int, error) {
+		return task, nil
+	})
+	rp.SetNumShards(2)
+	rp.SetPriorityMode(PriorityStrict)
+	rp.SetKeyFunc(func(task int) uint64 { return uint64(task % numKeys) })
+	rp.SetKeySerialization(true)
+
+	// The hooks and the key func see the tasks as submitted, and the tasks
+	// of a key start in order.
+	var mutex sync.Mutex
+	last := map[int]int{}
+	rp.SetHooks(&Hooks[int]{
+		OnTaskStart: func(shard int, task int) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if prev, ok := last[task%numKeys]; ok && prev > task {
+				t.Errorf("task %d started after task %d of the same key", task, prev)
+			}
+			last[task%numKeys] = task
+		},
+	})
+	rp.Start()
+	defer rp.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(numTasks)
+	var sum int64
+	for i := 0; i < numTasks; i++ {
+		callback := func(result int, err error) {
+			defer wg.Done()
+			if err != nil {
+				t.Errorf("task %d: unexpected error %v", result, err)
+			}
+			atomic.AddInt64(&sum, int64(result))
+		}
+
+		var err error
+		if i%2 == 0 {
+			err = rp.AddTaskPriority(PriorityHigh, i, callback)
+		} else {
+			err = rp.AddTaskKeyed(uint64(i%numKeys), i, callback)
+		}
+		if err != nil {
+			t.Fatalf("adding task %d: %v", i, err)
+		}
+	}
+	wg.Wait()
+
+	if want := int64(numTasks * (numTasks - 1) / 2); atomic.LoadInt64(&sum) != want {
+		t.Errorf("sum of results: got %d, want %d", sum, want)
+	}
+	mutex.Lock()
+	if len(last) != numKeys {
+		t.Errorf("keys seen by OnTaskStart: got %d, want %d", len(last), numKeys)
+	}
+	mutex.Unlock()
+}
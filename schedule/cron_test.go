@@ -0,0 +1,79 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronNext(t *testing.T) {
+	from := time.Date(2026, time.January, 30, 10, 17, 42, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"* * * * *", []string{"2026-01-30 10:18", "2026-01-30 10:19"}},
+		{"*/15 * * * *", []string{"2026-01-30 10:30", "2026-01-30 10:45", "2026-01-30 11:00"}},
+		{"5,50 9-11 * * *", []string{"2026-01-30 10:50", "2026-01-30 11:05", "2026-01-30 11:50", "2026-01-31 09:05"}},
+		{"0 0 * * *", []string{"2026-01-31 00:00", "2026-02-01 00:00"}},
+		{"@hourly", []string{"2026-01-30 11:00", "2026-01-30 12:00"}},
+		{"0 12 * * mon-wed", []string{"2026-02-02 12:00", "2026-02-03 12:00", "2026-02-04 12:00", "2026-02-09 12:00"}},
+		{"0 0 * * 7", []string{"2026-02-01 00:00", "2026-02-08 00:00"}},
+		{"30 6 31 * *", []string{"2026-01-31 06:30", "2026-03-31 06:30", "2026-05-31 06:30"}},
+		{"0 0 29 feb *", []string{"2028-02-29 00:00"}},
+		// Restricted day-of-month and day-of-week: either matches.
+		{"0 0 1 * fri", []string{"2026-02-01 00:00", "2026-02-06 00:00", "2026-02-13 00:00"}},
+		{"0 8-18/4 * JAN-feb 1-5", []string{"2026-01-30 12:00", "2026-01-30 16:00", "2026-02-02 08:00"}},
+		{"10/20 * * * *", []string{"2026-01-30 10:30", "2026-01-30 10:50", "2026-01-30 11:10"}},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseCronInLocation(tt.spec, time.UTC)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.spec, err)
+			continue
+		}
+
+		next := from
+		for _, want := range tt.want {
+			next = s.Next(next)
+			if got := next.Format("2006-01-02 15:04"); got != want {
+				t.Errorf("%q: got %s, want %s", tt.spec, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseCronNever(t *testing.T) {
+	s, err := ParseCronInLocation("0 0 30 2 *", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseCron: %v", err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Next of February 30th: got %v, want the zero time", next)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, tt := range []struct {
+		spec    string
+		wantErr string
+	}{
+		{"* * * *", "must have 5 fields, got 4"},
+		{"60 * * * *", `invalid minute "60"`},
+		{"* 24 * * *", `invalid hour "24"`},
+		{"* * 0 * *", `invalid day of month "0"`},
+		{"* * * foo *", `invalid month "foo"`},
+		{"* * * * 8", `invalid day of week "8"`},
+		{"5-1 * * * *", `invalid minute range "5-1"`},
+		{"*/0 * * * *", `invalid minute step "0"`},
+		{"@reboot", "must have 5 fields"},
+	} {
+		_, err := ParseCron(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseCron(%q): got %v, want error containing %q", tt.spec, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Package schedule submits recurring tasks into an ultrapool.WorkerPool, on
+// fixed intervals or standard 5-field cron expressions (parsed locally,
+// without dependencies). A single goroutine drives all jobs of a Scheduler,
+// instead of one ticker goroutine per job:
+//
+//	s := schedule.New[Job](wp)
+//	s.Add(schedule.Job[Job]{
+//		Schedule:      schedule.Every(time.Minute),
+//		Task:          func(done func()) Job { return Job{Kind: "sync", Done: done} },
+//		SkipIfRunning: true,
+//	})
+//	defer s.StopAndWait()
+//
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+package schedule
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maurice2k/ultrapool/v2"
+)
+
+var ErrStopped = errors.New("schedule: scheduler stopped")
+var ErrStillRunning = errors.New("schedule: previous run still running")
+
+const defaultRetryInterval = 100 * time.Millisecond
+
+// Pool is implemented by every ultrapool.WorkerPool[T].
+type Pool[T any] interface {
+	AddTask(task T) error
+	StopAndWait()
+
+	// Returns a channel that is closed once the pool is stopped.
+	Stopped() <-chan struct{}
+}
+
+// CatchUp selects what happens to runs that could not be submitted because
+// the pool was overloaded (ultrapool.ErrPoolOverload).
+type CatchUp int
+
+const (
+	// Missed runs are skipped; the job continues with its next run time.
+	CatchUpSkip CatchUp = iota
+	// Missed runs are retried every RetryInterval until one gets through;
+	// any number of missed runs is coalesced into a single one.
+	CatchUpOnce
+	// Every missed run is retried and submitted once the pool has room.
+	CatchUpAll
+)
+
+// Job describes a recurring task.
+type Job[T any] struct {
+	Schedule Schedule
+
+	// Creates the task of a run. done must be called once the run has
+	// finished (typically by the task handler); it is only needed for
+	// SkipIfRunning and may be called more than once.
+	Task func(done func()) T
+
+	// Delays every run by a random duration in [0, Jitter).
+	Jitter time.Duration
+
+	// Skips a run while the previous one has not called done yet.
+	SkipIfRunning bool
+
+	// What happens to runs rejected with ErrPoolOverload.
+	CatchUp CatchUp
+
+	// Interval between retries with CatchUpOnce and CatchUpAll; defaults to
+	// 100ms.
+	RetryInterval time.Duration
+
+	// Called for every run that is skipped, with ErrStillRunning or
+	// ultrapool.ErrPoolOverload. May be nil.
+	OnSkip func(scheduled time.Time, reason error)
+}
+
+// Entry is a job added to a Scheduler.
+type Entry[T any] struct {
+	job       Job[T]
+	sched     *Scheduler[T]
+	scheduled time.Time // next run time without jitter; zero if there is none
+	wakeAt    time.Time // next time the scheduler looks at the entry
+	pending   int       // runs that are due but not submitted yet
+	running   int32
+	index     int  // position in the heap; -1 while it's out of the heap
+	removed   bool // set by Remove
+}
+
+// Returns the next run time (without jitter), or the zero time if there is
+// none
+func (e *Entry[T]) Next() time.Time {
+	e.sched.mutex.Lock()
+	defer e.sched.mutex.Unlock()
+
+	return e.scheduled
+}
+
+// Removes the job from its scheduler. Runs already submitted are not
+// affected.
+func (e *Entry[T]) Remove() {
+	s := e.sched
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e.removed = true
+	if e.index >= 0 {
+		heap.Remove(&s.entries, e.index)
+	}
+}
+
+// Scheduler submits the runs of its jobs into a pool.
+type Scheduler[T any] struct {
+	pool        Pool[T]
+	poolStopped <-chan struct{}
+	mutex       sync.Mutex
+	entries     entryHeap[T]
+	wake        chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	stopped     bool
+}
+
+// Creates a scheduler for the given (started) pool and starts its goroutine.
+// The scheduler stops by itself once the pool is stopped: Add returns
+// ErrStopped then, and runs that are already due are dropped. Still, stop
+// the scheduler before the pool (see StopAndWait) for a clean shutdown,
+// which does not race with a run being submitted.
+func New[T any](pool Pool[T]) *Scheduler[T] {
+	s := &Scheduler[T]{
+		pool:        pool,
+		poolStopped: pool.Stopped(),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// Adds a job; its first run is the first time of its schedule after now
+func (s *Scheduler[T]) Add(job Job[T]) (*Entry[T], error) {
+	if job.Schedule == nil || job.Task == nil {
+		return nil, errors.New("schedule: job needs a Schedule and a Task")
+	}
+	if job.Jitter < 0 || job.RetryInterval < 0 {
+		return nil, errors.New("schedule: negative Jitter or RetryInterval")
+	}
+	if job.RetryInterval == 0 {
+		job.RetryInterval = defaultRetryInterval
+	}
+
+	e := &Entry[T]{job: job, sched: s}
+
+	s.mutex.Lock()
+	if s.stopped || s.isPoolStopped() {
+		s.mutex.Unlock()
+		return nil, ErrStopped
+	}
+	e.scheduled = job.Schedule.Next(time.Now())
+	if e.scheduled.IsZero() {
+		s.mutex.Unlock()
+		return nil, errors.New("schedule: schedule has no run time")
+	}
+	e.wakeAt = e.jittered()
+	heap.Push(&s.entries, e)
+	first := e.index == 0
+	s.mutex.Unlock()
+
+	if first {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return e, nil
+}
+
+// Stops scheduling and waits for the scheduler goroutine to exit. Runs
+// already submitted are left to the pool.
+func (s *Scheduler[T]) Stop() {
+	s.mutex.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.stop)
+	}
+	s.mutex.Unlock()
+
+	<-s.done
+}
+
+// Stops the scheduler, then the pool, and waits for all submitted runs to
+// finish.
+func (s *Scheduler[T]) StopAndWait() {
+	s.Stop()
+	s.pool.StopAndWait()
+}
+
+func (s *Scheduler[T]) run() {
+	defer close(s.done)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	var due []*Entry[T]
+	var scheduled []time.Time
+	for {
+		s.mutex.Lock()
+		now := time.Now()
+		due, scheduled = due[:0], scheduled[:0]
+		for len(s.entries) > 0 && !s.entries[0].wakeAt.After(now) {
+			e := heap.Pop(&s.entries).(*Entry[T])
+			due = append(due, e)
+			scheduled = append(scheduled, e.advance(now))
+		}
+		s.mutex.Unlock()
+
+		// The runs are submitted outside the lock, like the callbacks, so
+		// that neither Job.Task nor the pool can block Add, Remove or Next,
+		// or call them without deadlocking.
+		var callbacks []func()
+		stopped := false
+		for i, e := range due {
+			if !e.fire(scheduled[i], now, &callbacks) {
+				stopped = true
+				break
+			}
+		}
+
+		s.mutex.Lock()
+		for i, e := range due {
+			if !e.removed && !e.wakeAt.IsZero() {
+				heap.Push(&s.entries, e)
+			}
+			due[i] = nil
+		}
+		if stopped {
+			// The pool is stopped, so are we.
+			s.stopped = true
+			s.mutex.Unlock()
+			runCallbacks(callbacks)
+			return
+		}
+
+		var wait <-chan time.Time
+		if len(s.entries) > 0 {
+			timer.Reset(s.entries[0].wakeAt.Sub(now))
+			wait = timer.C
+		}
+		s.mutex.Unlock()
+
+		runCallbacks(callbacks)
+
+		select {
+		case <-wait:
+		case <-s.wake:
+			if wait != nil && !timer.Stop() {
+				// drain stale value (not required for Go 1.23+)
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-s.stop:
+			return
+		case <-s.poolStopped:
+			s.mutex.Lock()
+			s.stopped = true
+			s.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// isPoolStopped reports whether the pool is stopped, without waiting
+func (s *Scheduler[T]) isPoolStopped() bool {
+	select {
+	case <-s.poolStopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+// advance counts the runs of the entry that are due and moves its run time
+// past now. Returns the time of the latest due run, or now for a retry. Must
+// be called under the scheduler lock.
+func (e *Entry[T]) advance(now time.Time) time.Time {
+	job := &e.job
+
+	var scheduled time.Time
+	for !e.scheduled.IsZero() && !e.scheduled.After(now) {
+		scheduled = e.scheduled
+		e.pending++
+		e.scheduled = job.Schedule.Next(e.scheduled)
+	}
+	if scheduled.IsZero() {
+		scheduled = now // a retry
+	}
+	// Runs missed while the process was not scheduled (or the pool
+	// overloaded) are coalesced unless every run must be caught up.
+	if job.CatchUp != CatchUpAll && e.pending > 1 {
+		e.pending = 1
+	}
+
+	return scheduled
+}
+
+// fire submits the pending runs of an entry that advance took out of the
+// heap, and sets its next wake time (zero if it has no runs left).
+// Callbacks are appended to callbacks. Returns false if the pool is stopped.
+func (e *Entry[T]) fire(scheduled, now time.Time, callbacks *[]func()) bool {
+	job := &e.job
+
+	for e.pending > 0 {
+		if job.SkipIfRunning && !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+			e.pending = 0
+			e.skipped(scheduled, ErrStillRunning, callbacks)
+			break
+		}
+
+		err := e.submit()
+		if err == nil {
+			e.pending--
+			continue
+		}
+		if err == ultrapool.ErrPoolOverload {
+			e.skipped(scheduled, err, callbacks)
+			if job.CatchUp == CatchUpSkip {
+				e.pending = 0
+			}
+			break
+		}
+
+		return false
+	}
+
+	switch {
+	case e.pending > 0:
+		e.wakeAt = now.Add(job.RetryInterval)
+		if next := e.jittered(); !next.IsZero() && next.Before(e.wakeAt) {
+			e.wakeAt = next
+		}
+	case e.scheduled.IsZero():
+		e.wakeAt = time.Time{}
+	default:
+		e.wakeAt = e.jittered()
+	}
+
+	return true
+}
+
+// submit adds a run's task to the pool, marking the entry as running for
+// SkipIfRunning until the run calls done
+func (e *Entry[T]) submit() error {
+	var once sync.Once
+	done := func() {
+		once.Do(func() { atomic.StoreInt32(&e.running, 0) })
+	}
+
+	err := e.sched.pool.AddTask(e.job.Task(done))
+	if err != nil {
+		done()
+	}
+
+	return err
+}
+
+func (e *Entry[T]) skipped(scheduled time.Time, reason error, callbacks *[]func()) {
+	if e.job.OnSkip != nil {
+		onSkip := e.job.OnSkip
+		*callbacks = append(*callbacks, func() { onSkip(scheduled, reason) })
+	}
+}
+
+// jittered returns the next run time plus a random jitter
+func (e *Entry[T]) jittered() time.Time {
+	if e.scheduled.IsZero() || e.job.Jitter <= 0 {
+		return e.scheduled
+	}
+
+	return e.scheduled.Add(time.Duration(rand.Int63n(int64(e.job.Jitter))))
+}
+
+// entryHeap is a min-heap of entries ordered by wake time
+type entryHeap[T any] []*Entry[T]
+
+func (h entryHeap[T]) Len() int           { return len(h) }
+func (h entryHeap[T]) Less(i, j int) bool { return h[i].wakeAt.Before(h[j].wakeAt) }
+
+func (h entryHeap[T]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap[T]) Push(x any) {
+	e := x.(*Entry[T])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap[T]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+
+	return e
+}
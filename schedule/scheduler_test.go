@@ -0,0 +1,253 @@
+package schedule
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maurice2k/ultrapool/v2"
+)
+
+func TestSchedulerEvery(t *testing.T) {
+	var runs int64
+	wp := ultrapool.NewWorkerPool(func(task func()) { task() })
+	wp.Start()
+
+	s := New[func()](wp)
+	_, err := s.Add(Job[func()]{
+		Schedule: Every(5 * time.Millisecond),
+		Jitter:   time.Millisecond,
+		Task: func(done func()) func() {
+			return func() {
+				atomic.AddInt64(&runs, 1)
+				done()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	s.StopAndWait()
+
+	got := atomic.LoadInt64(&runs)
+	if got < 3 || got > 12 {
+		t.Errorf("runs in 60ms at 5ms intervals: got %d, want 3..12", got)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if after := atomic.LoadInt64(&runs); after != got {
+		t.Errorf("runs after StopAndWait: got %d more", after-got)
+	}
+	if _, err := s.Add(Job[func()]{Schedule: Every(time.Second), Task: func(done func()) func() { return done }}); err != ErrStopped {
+		t.Errorf("Add after Stop: got %v, want ErrStopped", err)
+	}
+}
+
+func TestSchedulerSkipIfRunning(t *testing.T) {
+	var started, skipped int64
+	release := make(chan struct{})
+
+	wp := ultrapool.NewWorkerPool(func(task func()) { task() })
+	wp.Start()
+
+	s := New[func()](wp)
+	_, err := s.Add(Job[func()]{
+		Schedule:      Every(2 * time.Millisecond),
+		SkipIfRunning: true,
+		Task: func(done func()) func() {
+			return func() {
+				defer done()
+				atomic.AddInt64(&started, 1)
+				<-release
+			}
+		},
+		OnSkip: func(scheduled time.Time, reason error) {
+			if reason != ErrStillRunning {
+				t.Errorf("OnSkip: got %v, want ErrStillRunning", reason)
+			}
+			atomic.AddInt64(&skipped, 1)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if got := atomic.LoadInt64(&started); got != 1 {
+		t.Errorf("runs started while the first one blocks: got %d, want 1", got)
+	}
+	if atomic.LoadInt64(&skipped) == 0 {
+		t.Error("no skipped runs reported")
+	}
+
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	s.StopAndWait()
+
+	if got := atomic.LoadInt64(&started); got < 2 {
+		t.Errorf("runs after release: got %d, want more than 1", got)
+	}
+}
+
+// overloadedPool rejects the first rejections submissions with
+// ErrPoolOverload and records the accepted tasks.
+type overloadedPool struct {
+	mutex      sync.Mutex
+	rejections int
+	accepted   []time.Time
+}
+
+func (p *overloadedPool) AddTask(task int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.rejections > 0 {
+		p.rejections--
+		return ultrapool.ErrPoolOverload
+	}
+	p.accepted = append(p.accepted, time.Now())
+
+	return nil
+}
+
+func (p *overloadedPool) StopAndWait() {}
+
+func (p *overloadedPool) Stopped() <-chan struct{} { return nil }
+
+func (p *overloadedPool) acceptedRuns() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.accepted)
+}
+
+// burstSchedule has a few run times right after each other, so that they
+// are all due when the scheduler wakes up.
+type burstSchedule struct {
+	times []time.Time
+}
+
+func (s *burstSchedule) Next(t time.Time) time.Time {
+	for _, at := range s.times {
+		if at.After(t) {
+			return at
+		}
+	}
+
+	return time.Time{}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	for _, tt := range []struct {
+		catchUp      CatchUp
+		wantAccepted int
+		wantSkipped  int64
+	}{
+		{CatchUpSkip, 0, 1},
+		{CatchUpOnce, 1, 5},
+		{CatchUpAll, 3, 5},
+	} {
+		pool := &overloadedPool{rejections: 5}
+		var skipped int64
+
+		first := time.Now().Add(10 * time.Millisecond)
+		s := New[int](pool)
+		_, err := s.Add(Job[int]{
+			Schedule:      &burstSchedule{times: []time.Time{first, first.Add(1), first.Add(2)}},
+			Task:          func(done func()) int { return 0 },
+			CatchUp:       tt.catchUp,
+			RetryInterval: time.Millisecond,
+			OnSkip: func(scheduled time.Time, reason error) {
+				if reason != ultrapool.ErrPoolOverload {
+					t.Errorf("OnSkip: got %v, want ErrPoolOverload", reason)
+				}
+				atomic.AddInt64(&skipped, 1)
+			},
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		s.Stop()
+
+		if got := pool.acceptedRuns(); got != tt.wantAccepted {
+			t.Errorf("catch-up %d: accepted runs: got %d, want %d", tt.catchUp, got, tt.wantAccepted)
+		}
+		if got := atomic.LoadInt64(&skipped); got != tt.wantSkipped {
+			t.Errorf("catch-up %d: OnSkip calls: got %d, want %d", tt.catchUp, got, tt.wantSkipped)
+		}
+	}
+}
+
+func TestSchedulerStopsWithPool(t *testing.T) {
+	wp := ultrapool.NewWorkerPool(func(task int) {})
+	wp.Start()
+
+	s := New[int](wp)
+	job := Job[int]{Schedule: Every(time.Hour), Task: func(done func()) int { return 0 }}
+	if _, err := s.Add(job); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	wp.StopAndWait()
+
+	// The scheduler notices the stopped pool without waiting for a run.
+	select {
+	case <-s.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduler still running after the pool stopped")
+	}
+	if _, err := s.Add(job); err != ErrStopped {
+		t.Errorf("Add after the pool stopped: got %v, want ErrStopped", err)
+	}
+	s.Stop()
+}
+
+func TestSchedulerTaskCallsScheduler(t *testing.T) {
+	wp := ultrapool.NewWorkerPool(func(task int) {})
+	wp.Start()
+
+	// Job.Task runs outside the scheduler lock, so it may add jobs, even
+	// removing its own entry.
+	s := New[int](wp)
+	added := make(chan *Entry[int], 1)
+	var once sync.Once
+	entry, err := s.Add(Job[int]{
+		Schedule: Every(time.Millisecond),
+		Task: func(done func()) int {
+			once.Do(func() {
+				e, err := s.Add(Job[int]{Schedule: Every(time.Hour), Task: func(done func()) int { return 1 }})
+				if err != nil {
+					t.Errorf("Add from Job.Task: %v", err)
+				}
+				added <- e
+			})
+			return 0
+		},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	select {
+	case e := <-added:
+		if e != nil && e.Next().IsZero() {
+			t.Error("job added from Job.Task has no next run time")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Add from Job.Task did not return")
+	}
+
+	entry.Remove()
+	time.Sleep(5 * time.Millisecond)
+	s.mutex.Lock()
+	inHeap := entry.index >= 0
+	s.mutex.Unlock()
+	if inHeap {
+		t.Error("removed entry is back in the heap")
+	}
+	s.StopAndWait()
+}
@@ -0,0 +1,155 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// shardTable is the immutable set of shards dispatchers pick from.
+// ResizeShards swaps the whole table at once.
+type shardTable[T any] struct {
+	shards  []*poolShard[T] // active shards
+	removed []*poolShard[T] // removed shards, possibly still draining
+	drained shardTotals     // counters of removed shards that have drained
+}
+
+// shardTotals keeps the counters of drained shards, so that the pool-wide
+// counters in Stats stay monotonic across ResizeShards.
+type shardTotals struct {
+	completed uint64
+	rejected  uint64
+	stolen    uint64
+	spawned   uint64
+	retired   uint64
+	queueWait *histogramSnapshot // only set in timing mode
+	execution *histogramSnapshot
+	batchSize *histogramSnapshot // only set in batched execution mode
+}
+
+// add returns the totals plus the (final) counters of a drained shard
+func (t shardTotals) add(ss ShardStats) shardTotals {
+	t.completed += ss.Completed
+	t.rejected += ss.Rejected
+	t.stolen += ss.Stolen
+	t.spawned += ss.Spawned
+	t.retired += ss.Retired
+
+	if ss.QueueWait.hist != nil {
+		// Merge into fresh snapshots; the old ones belong to a published table.
+		queueWait, execution := &histogramSnapshot{}, &histogramSnapshot{}
+		if t.queueWait != nil {
+			queueWait.merge(t.queueWait)
+			execution.merge(t.execution)
+		}
+		queueWait.merge(ss.QueueWait.hist)
+		execution.merge(ss.Execution.hist)
+		t.queueWait, t.execution = queueWait, execution
+	}
+	if ss.BatchSize.hist != nil {
+		batchSize := &histogramSnapshot{}
+		if t.batchSize != nil {
+			batchSize.merge(t.batchSize)
+		}
+		batchSize.merge(ss.BatchSize.hist)
+		t.batchSize = batchSize
+	}
+
+	return t
+}
+
+// Changes the number of shards (1 to 128) of a (possibly running) pool.
+//
+// New shards start with the per-shard floor of workers. Removed shards stop
+// taking tasks right away; their workers finish the tasks already buffered
+// and then exit (see WorkerExitRemoved). Dispatchers pick up the new shard
+// set atomically, so tasks can be added throughout.
+func (wp *WorkerPool[T]) ResizeShards(n int) error {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	if n < 1 || n > maxShards {
+		return fmt.Errorf("ultrapool: number of shards must be in [1, %d], got %d", maxShards, n)
+	}
+	if err := wp.config().validate(n); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&wp.stopped) != 0 {
+		return ErrPoolStopped
+	}
+
+	wp.resizeShards(n)
+
+	return nil
+}
+
+// resizeShards puts a validated shard count into effect; wp.mutex must be held
+func (wp *WorkerPool[T]) resizeShards(n int) {
+	wp.numShards = n
+
+	if !wp.started {
+		return
+	}
+
+	old := wp.shards.Load()
+	table := &shardTable[T]{
+		shards:  make([]*poolShard[T], 0, n),
+		drained: old.drained,
+	}
+
+	// Fold shards removed earlier into the totals once they have drained.
+	// Floor workers only exit on the closed queue, so a removed shard
+	// without workers has no tasks left in its queue. Its counters are final
+	// once the tasks taken elsewhere (stolen or waiting in a key backlog)
+	// have completed, too.
+	for _, shard := range old.removed {
+		completed := atomic.LoadUint64(&shard.completed)
+		if atomic.LoadInt64(&shard.workers) == 0 && atomic.LoadUint64(&shard.submitted) == completed {
+			table.drained = table.drained.add(shard.stats())
+		} else {
+			table.removed = append(table.removed, shard)
+		}
+	}
+
+	var removed []*poolShard[T]
+	if n < len(old.shards) {
+		table.shards = append(table.shards, old.shards[:n]...)
+		removed = old.shards[n:]
+		table.removed = append(table.removed, removed...)
+	} else {
+		table.shards = append(table.shards, old.shards...)
+		for i := len(old.shards); i < n; i++ {
+			table.shards = append(table.shards, wp.startShard(i))
+		}
+	}
+
+	wp.shards.Store(table)
+
+	// Dispatchers that still hold the old table see shard.removed under
+	// tqLock and retry on the new one.
+	for _, shard := range removed {
+		shard.tqLock.Lock()
+		shard.removed = true
+		shard.closeQueues()
+		shard.tqLock.Unlock()
+	}
+}
+
+// closeQueues closes the shard's task queue and priority lanes; tqLock must
+// be held exclusively
+func (shard *poolShard[T]) closeQueues() {
+	if shard.queue != nil {
+		close(shard.queue.done)
+		return
+	}
+	if shard.lanes == nil {
+		close(shard.taskQueue)
+		return
+	}
+
+	for _, queue := range shard.lanes.queues {
+		close(queue)
+	}
+}
@@ -0,0 +1,234 @@
+package ultrapool
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResizeShardsGrowAndShrink(t *testing.T) {
+	var mu sync.Mutex
+	reasons := map[WorkerExitReason]int{}
+
+	wp := NewWorkerPool(func(task int) {})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(1)
+	wp.SetHooks(&Hooks[int]{
+		OnWorkerExit: func(shard int, reason WorkerExitReason) {
+			mu.Lock()
+			reasons[reason]++
+			mu.Unlock()
+		},
+	})
+	wp.Start()
+	defer wp.Stop()
+
+	if err := wp.ResizeShards(5); err != nil {
+		t.Fatalf("ResizeShards(5): %v", err)
+	}
+	if got := wp.GetNumShards(); got != 5 {
+		t.Errorf("GetNumShards after growing: got %d, want 5", got)
+	}
+	// New shards come up with their floor workers synchronously.
+	if got := wp.GetSpawnedWorkers(); got != 5 {
+		t.Errorf("spawned workers after growing: got %d, want 5", got)
+	}
+	if got := len(wp.Stats().Shards); got != 5 {
+		t.Errorf("shard stats after growing: got %d entries, want 5", got)
+	}
+
+	if err := wp.ResizeShards(1); err != nil {
+		t.Fatalf("ResizeShards(1): %v", err)
+	}
+	if got := wp.GetNumShards(); got != 1 {
+		t.Errorf("GetNumShards after shrinking: got %d, want 1", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && wp.GetSpawnedWorkers() > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if got := wp.GetSpawnedWorkers(); got != 1 {
+		t.Errorf("spawned workers after shrinking: got %d, want 1", got)
+	}
+
+	mu.Lock()
+	if reasons[WorkerExitRemoved] != 4 {
+		t.Errorf("OnWorkerExit(removed) calls: got %d, want 4", reasons[WorkerExitRemoved])
+	}
+	mu.Unlock()
+
+	for i := 0; i < 100; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.Wait()
+	if got := wp.Stats().Shards[0].Completed; got != 100 {
+		t.Errorf("tasks completed by the remaining shard: got %d, want 100", got)
+	}
+}
+
+func TestResizeShardsDrainsRemovedShards(t *testing.T) {
+	const numTasks = 64
+
+	release := make(chan struct{})
+	var completed int64
+	wp := NewWorkerPool(func(task int) {
+		<-release
+		atomic.AddInt64(&completed, 1)
+	})
+	wp.SetNumShards(4)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.SetQueueSize(numTasks)
+	wp.Start()
+	defer wp.Stop()
+
+	for i := 0; i < numTasks; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask(%d): %v", i, err)
+		}
+	}
+
+	if err := wp.ResizeShards(1); err != nil {
+		t.Fatalf("ResizeShards(1): %v", err)
+	}
+
+	// Tasks buffered in removed shards still count as in flight...
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	if err := wp.WaitContext(ctx); err == nil {
+		t.Errorf("WaitContext returned before the removed shards drained")
+	}
+	cancel()
+
+	// ...and are executed by the workers of the removed shards.
+	close(release)
+	wp.Wait()
+	if got := atomic.LoadInt64(&completed); got != numTasks {
+		t.Errorf("completed tasks: got %d, want %d", got, numTasks)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && wp.GetSpawnedWorkers() > 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Pool-wide counters survive the removal, including after the drained
+	// shards are folded into the totals by the next resize.
+	for _, n := range []int{1, 3} {
+		if err := wp.ResizeShards(n); err != nil {
+			t.Fatalf("ResizeShards(%d): %v", n, err)
+		}
+		stats := wp.Stats()
+		if stats.Completed != numTasks {
+			t.Errorf("Stats.Completed after ResizeShards(%d): got %d, want %d", n, stats.Completed, numTasks)
+		}
+		if stats.Spawned != uint64(3+n) {
+			t.Errorf("Stats.Spawned after ResizeShards(%d): got %d, want %d", n, stats.Spawned, 3+n)
+		}
+	}
+}
+
+func TestResizeShardsValidation(t *testing.T) {
+	wp := NewWorkerPool(func(task int) {})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(2)
+	wp.SetMaxWorkers(8)
+
+	for _, tt := range []struct {
+		n       int
+		wantErr string
+	}{
+		{0, "number of shards must be in [1, 128], got 0"},
+		{maxShards + 1, "number of shards must be in [1, 128], got 129"},
+		{5, "5 shards with 2 min workers each need 10 workers, but max workers is 8"},
+	} {
+		err := wp.ResizeShards(tt.n)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ResizeShards(%d): got %v, want error containing %q", tt.n, err, tt.wantErr)
+		}
+	}
+
+	// Before Start, ResizeShards acts like SetNumShards.
+	if err := wp.ResizeShards(4); err != nil {
+		t.Fatalf("ResizeShards(4): %v", err)
+	}
+	wp.Start()
+	if got := wp.GetNumShards(); got != 4 {
+		t.Errorf("GetNumShards after Start: got %d, want 4", got)
+	}
+	if got := wp.GetSpawnedWorkers(); got != 8 {
+		t.Errorf("spawned workers after Start: got %d, want 8", got)
+	}
+
+	wp.StopAndWait()
+	if err := wp.ResizeShards(2); err != ErrPoolStopped {
+		t.Errorf("ResizeShards after stop: got %v, want ErrPoolStopped", err)
+	}
+}
+
+func TestResizeShardsUnderLoad(t *testing.T) {
+	var completed int64
+
+	wp := NewWorkerPool(func(task int) {
+		time.Sleep(10 * time.Microsecond)
+		atomic.AddInt64(&completed, 1)
+	})
+	wp.SetNumShards(4)
+	wp.SetQueueSize(16)
+	wp.Start()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := wp.ResizeShards(1 + i%7); err != nil {
+				t.Errorf("ResizeShards: %v", err)
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	const producers = 8
+	const tasksPerProducer = 500
+	var pwg sync.WaitGroup
+	pwg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer pwg.Done()
+			for i := 0; i < tasksPerProducer; i++ {
+				if err := wp.AddTaskWithBlocking(i); err != nil {
+					t.Errorf("AddTaskWithBlocking: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	pwg.Wait()
+	close(stop)
+	wg.Wait()
+
+	wp.StopAndWait()
+
+	if got := atomic.LoadInt64(&completed); got != producers*tasksPerProducer {
+		t.Errorf("completed tasks: got %d, want %d", got, producers*tasksPerProducer)
+	}
+	if got := wp.Stats().Completed; got != producers*tasksPerProducer {
+		t.Errorf("Stats.Completed: got %d, want %d", got, producers*tasksPerProducer)
+	}
+	if got := wp.GetSpawnedWorkers(); got != 0 {
+		t.Errorf("spawned workers after StopAndWait: got %d, want 0", got)
+	}
+}
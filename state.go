@@ -0,0 +1,46 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+// WorkerState is the private state of a single worker goroutine. Each worker
+// creates its own state when it starts and passes it to every task it
+// handles, so the state can be used without synchronization.
+type WorkerState[S any] struct {
+	State S
+	Shard int
+}
+
+// StateHandlerFunc is a task handler that receives the state of the worker
+// running it.
+type StateHandlerFunc[T, S any] func(ws *WorkerState[S], task T)
+
+// Creates a new WorkerPool whose workers each own a state created by
+// newState when the worker starts. destroyState (may be nil) is called with
+// that state when the worker exits, either after its idle timeout or on
+// Stop; StopAndWait waits for it.
+//
+// This suits reusable encoders, hash states or connections that would
+// otherwise churn through a sync.Pool.
+func NewWorkerPoolWithState[T, S any](newState func() S, destroyState func(state S), handlerFunc StateHandlerFunc[T, S]) *WorkerPool[T] {
+	wp := NewWorkerPool[T](nil)
+	wp.newWorker = func(shard int) (TaskHandlerFunc[T], func()) {
+		ws := &WorkerState[S]{
+			State: newState(),
+			Shard: shard,
+		}
+
+		handler := func(task T) {
+			handlerFunc(ws, task)
+		}
+		release := func() {
+			if destroyState != nil {
+				destroyState(ws.State)
+			}
+		}
+
+		return handler, release
+	}
+
+	return wp
+}
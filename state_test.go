@@ -0,0 +1,72 @@
+package ultrapool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testWorkerState struct {
+	inUse   int32
+	handled int
+}
+
+func TestWorkerPoolWithState(t *testing.T) {
+	const numTasks = 500
+
+	var created, destroyed, handled int64
+
+	wp := NewWorkerPoolWithState(
+		func() *testWorkerState {
+			atomic.AddInt64(&created, 1)
+			return &testWorkerState{}
+		},
+		func(state *testWorkerState) {
+			atomic.AddInt64(&destroyed, 1)
+			atomic.AddInt64(&handled, int64(state.handled))
+		},
+		func(ws *WorkerState[*testWorkerState], task int) {
+			if !atomic.CompareAndSwapInt32(&ws.State.inUse, 0, 1) {
+				t.Error("worker state used by two tasks concurrently")
+			}
+			if ws.Shard < 0 || ws.Shard >= 2 {
+				t.Errorf("unexpected shard index %d", ws.Shard)
+			}
+			time.Sleep(100 * time.Microsecond)
+			ws.State.handled++
+			atomic.StoreInt32(&ws.State.inUse, 0)
+		},
+	)
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(2)
+	wp.SetShardMaxWorkers(8)
+	wp.SetIdleWorkerLifetime(10 * time.Millisecond)
+	wp.Start()
+
+	// Floor workers create their state as soon as they are scheduled.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt64(&created) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	if got := atomic.LoadInt64(&created); got != 4 {
+		t.Errorf("states after Start: got %d, want 4 (one per floor worker)", got)
+	}
+
+	for i := 0; i < numTasks; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+
+	wp.StopAndWait()
+
+	if got := uint64(atomic.LoadInt64(&created)); got != wp.Stats().Spawned {
+		t.Errorf("states created: got %d, want %d (one per spawned worker)", got, wp.Stats().Spawned)
+	}
+	if created != destroyed {
+		t.Errorf("states destroyed: got %d, want %d", destroyed, created)
+	}
+	if handled != numTasks {
+		t.Errorf("tasks handled across all states: got %d, want %d", handled, numTasks)
+	}
+}
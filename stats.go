@@ -0,0 +1,199 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of a pool's gauges and counters. The values are read
+// one by one while the pool keeps running, so they are not mutually
+// consistent to the nanosecond, but every counter is monotonic.
+type Stats struct {
+	QueueLen    int // tasks buffered in the shard queues (all priority lanes)
+	KeyBacklog  int // keyed tasks waiting for their predecessor (see SetKeySerialization)
+	Workers     int // currently spawned workers
+	BusyWorkers int // workers executing a task
+	IdleWorkers int // workers waiting for a task
+	Waiters     int // callers blocked in AddTaskWithBlocking*
+	Delayed     int // tasks added with AddTaskAfter/AddTaskAt that are not due yet
+
+	// Estimated size of the queued tasks; only populated in overflow mode
+	// with a SizeFunc (see SetOverflowPolicy)
+	QueuedBytes int64
+
+	Completed uint64 // tasks executed
+	Rejected  uint64 // submissions rejected with ErrPoolOverload
+	Discarded uint64 // tasks dropped by the rejection policy (see SetRejectionPolicy)
+	Stolen    uint64 // tasks taken by workers of other shards (see SetWorkStealing)
+	Spawned   uint64 // workers spawned, including the initial ones
+	Retired   uint64 // workers retired after their idle timeout or a lowered cap
+
+	// Latency histograms; only populated in timing mode (see SetTaskTiming)
+	QueueWait LatencyStats // time from dispatch until a worker picks the task up
+	Execution LatencyStats // time spent in the task handler
+
+	// Batch size distribution; only populated in batched execution mode (see
+	// NewBatchWorkerPool)
+	BatchSize BatchStats
+
+	Shards []ShardStats
+}
+
+// ShardStats holds the per-shard part of Stats.
+type ShardStats struct {
+	QueueLen    int
+	KeyBacklog  int
+	Workers     int
+	BusyWorkers int
+	IdleWorkers int
+
+	Completed uint64
+	Rejected  uint64
+	Stolen    uint64
+	Spawned   uint64
+	Retired   uint64
+
+	QueueWait LatencyStats
+	Execution LatencyStats
+
+	BatchSize BatchStats
+}
+
+// Returns a snapshot of the pool's gauges and counters. Shards only lists
+// the active shards, but the pool-wide values include shards removed by
+// ResizeShards.
+func (wp *WorkerPool[T]) Stats() Stats {
+	stats := Stats{
+		Waiters:   int(atomic.LoadUint64(&wp.waiters)),
+		Delayed:   wp.delayedLen(),
+		Discarded: atomic.LoadUint64(&wp.discarded),
+	}
+
+	table := wp.shards.Load()
+	if table == nil {
+		stats.Shards = []ShardStats{}
+		return stats
+	}
+
+	if wp.overflow != nil {
+		stats.QueuedBytes = atomic.LoadInt64(&wp.overflow.bytes)
+	}
+
+	stats.Shards = make([]ShardStats, len(table.shards))
+
+	var queueWait, execution, batchSize *histogramSnapshot
+	add := func(ss *ShardStats, hasTiming bool) {
+		stats.QueueLen += ss.QueueLen
+		stats.KeyBacklog += ss.KeyBacklog
+		stats.Workers += ss.Workers
+		stats.BusyWorkers += ss.BusyWorkers
+		stats.IdleWorkers += ss.IdleWorkers
+		stats.Completed += ss.Completed
+		stats.Rejected += ss.Rejected
+		stats.Stolen += ss.Stolen
+		stats.Spawned += ss.Spawned
+		stats.Retired += ss.Retired
+
+		if hasTiming {
+			if queueWait == nil {
+				queueWait, execution = &histogramSnapshot{}, &histogramSnapshot{}
+			}
+			queueWait.merge(ss.QueueWait.hist)
+			execution.merge(ss.Execution.hist)
+		}
+		if ss.BatchSize.hist != nil {
+			if batchSize == nil {
+				batchSize = &histogramSnapshot{}
+			}
+			batchSize.merge(ss.BatchSize.hist)
+		}
+	}
+
+	for i, shard := range table.shards {
+		stats.Shards[i] = shard.stats()
+		add(&stats.Shards[i], shard.timing != nil)
+	}
+	for _, shard := range table.removed {
+		ss := shard.stats()
+		add(&ss, shard.timing != nil)
+	}
+
+	drained := table.drained
+	add(&ShardStats{
+		Completed: drained.completed,
+		Rejected:  drained.rejected,
+		Stolen:    drained.stolen,
+		Spawned:   drained.spawned,
+		Retired:   drained.retired,
+		QueueWait: LatencyStats{hist: drained.queueWait},
+		Execution: LatencyStats{hist: drained.execution},
+		BatchSize: BatchStats{hist: drained.batchSize},
+	}, drained.queueWait != nil)
+
+	if queueWait != nil {
+		stats.QueueWait = newLatencyStats(queueWait)
+		stats.Execution = newLatencyStats(execution)
+	}
+	if batchSize != nil {
+		stats.BatchSize = newBatchStats(batchSize)
+	}
+
+	return stats
+}
+
+// stats derives busy workers from the in-flight count minus the buffered
+// and backlogged tasks, which avoids tracking busy state on the hot path.
+func (shard *poolShard[T]) stats() ShardStats {
+	completed := atomic.LoadUint64(&shard.completed)
+	inflight := int(atomic.LoadUint64(&shard.submitted) - completed)
+
+	ss := ShardStats{
+		QueueLen:   shard.queueLen(),
+		KeyBacklog: int(atomic.LoadInt64(&shard.backlogged)),
+		Workers:    int(atomic.LoadInt64(&shard.workers)),
+		Completed:  completed,
+		Rejected:   atomic.LoadUint64(&shard.rejected),
+		Stolen:     atomic.LoadUint64(&shard.stolen),
+		Spawned:    atomic.LoadUint64(&shard.spawned),
+		Retired:    atomic.LoadUint64(&shard.retired),
+	}
+
+	busy := inflight - ss.QueueLen - ss.KeyBacklog
+	if busy < 0 {
+		busy = 0
+	}
+	if busy > ss.Workers {
+		busy = ss.Workers
+	}
+	ss.BusyWorkers = busy
+	ss.IdleWorkers = ss.Workers - busy
+
+	if shard.timing != nil {
+		ss.QueueWait = newLatencyStats(shard.timing.queueWait.snapshot())
+		ss.Execution = newLatencyStats(shard.timing.execution.snapshot())
+	}
+	if shard.batches != nil {
+		ss.BatchSize = newBatchStats(shard.batches.snapshot())
+	}
+
+	return ss
+}
+
+// queueLen returns the number of tasks buffered in all lanes
+func (shard *poolShard[T]) queueLen() int {
+	if shard.queue != nil {
+		return shard.queue.len()
+	}
+	if shard.lanes == nil {
+		return len(shard.taskQueue)
+	}
+
+	n := 0
+	for _, queue := range shard.lanes.queues {
+		n += len(queue)
+	}
+
+	return n
+}
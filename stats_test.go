@@ -0,0 +1,165 @@
+package ultrapool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	const queueSize = 16
+	const shardMax = 2
+
+	wp, _, releaseAll := engageBlockedPool(t, shardMax, queueSize, time.Hour)
+	defer wp.Stop()
+	defer releaseAll()
+
+	for i := 0; i < queueSize; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask buffer fill %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := wp.AddTask(9999); err != ErrPoolOverload {
+			t.Fatalf("AddTask on saturated pool: got %v, want ErrPoolOverload", err)
+		}
+	}
+
+	stats := wp.Stats()
+	if len(stats.Shards) != 1 {
+		t.Fatalf("shard stats: got %d entries, want 1", len(stats.Shards))
+	}
+	if stats.QueueLen != queueSize {
+		t.Errorf("QueueLen: got %d, want %d", stats.QueueLen, queueSize)
+	}
+	if stats.Workers != shardMax || stats.BusyWorkers != shardMax || stats.IdleWorkers != 0 {
+		t.Errorf("workers: got %d (busy %d, idle %d), want %d (busy %d, idle 0)",
+			stats.Workers, stats.BusyWorkers, stats.IdleWorkers, shardMax, shardMax)
+	}
+	if stats.Rejected != 3 {
+		t.Errorf("Rejected: got %d, want 3", stats.Rejected)
+	}
+	if stats.Spawned != shardMax {
+		t.Errorf("Spawned: got %d, want %d", stats.Spawned, shardMax)
+	}
+	if stats.Completed != 0 {
+		t.Errorf("Completed: got %d, want 0", stats.Completed)
+	}
+	if stats.Shards[0].QueueLen != stats.QueueLen || stats.Shards[0].Rejected != stats.Rejected {
+		t.Errorf("pool totals do not match the single shard: %+v vs %+v", stats, stats.Shards[0])
+	}
+
+	releaseAll()
+	wp.Wait()
+
+	stats = wp.Stats()
+	if stats.Completed != shardMax+queueSize {
+		t.Errorf("Completed after release: got %d, want %d", stats.Completed, shardMax+queueSize)
+	}
+	if stats.QueueLen != 0 || stats.BusyWorkers != 0 {
+		t.Errorf("after release: QueueLen %d, BusyWorkers %d, want 0 and 0", stats.QueueLen, stats.BusyWorkers)
+	}
+}
+
+func TestStatsDuringStart(t *testing.T) {
+	wp := NewWorkerPool(func(task int) {})
+
+	// Stats may be called while Start is running (run with -race).
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_ = wp.Stats()
+		}
+	}()
+	wp.Start()
+	<-done
+	wp.StopAndWait()
+
+	if got := wp.Stats().Delayed; got != 0 {
+		t.Errorf("Stats.Delayed: got %d, want 0", got)
+	}
+}
+
+func TestStatsRetired(t *testing.T) {
+	const queueSize = 16
+	const shardMax = 4
+
+	wp, _, releaseAll := engageBlockedPool(t, shardMax, queueSize, 20*time.Millisecond)
+	defer wp.Stop()
+	releaseAll()
+
+	// Everything above the floor of 1 retires after the idle lifetime.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && wp.Stats().Retired < shardMax-1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stats := wp.Stats()
+	if stats.Retired != shardMax-1 {
+		t.Errorf("Retired: got %d, want %d", stats.Retired, shardMax-1)
+	}
+	if stats.Workers != 1 {
+		t.Errorf("Workers after retirement: got %d, want 1", stats.Workers)
+	}
+}
+
+func TestStatsTaskTiming(t *testing.T) {
+	const numTasks = 100
+	const taskDuration = 2 * time.Millisecond
+
+	wp := NewWorkerPool(func(task int) {
+		time.Sleep(taskDuration)
+	})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.SetTaskTiming(true)
+	wp.Start()
+	defer wp.Stop()
+
+	for i := 0; i < numTasks; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+	wp.Wait()
+
+	stats := wp.Stats()
+	if stats.Execution.Count != numTasks || stats.QueueWait.Count != numTasks {
+		t.Fatalf("sample counts: execution %d, queue wait %d, want %d each",
+			stats.Execution.Count, stats.QueueWait.Count, numTasks)
+	}
+	if stats.Execution.P50 < taskDuration {
+		t.Errorf("Execution.P50: got %v, want >= %v", stats.Execution.P50, taskDuration)
+	}
+	// Two single-worker shards fed 100 tasks at once: most tasks queue for
+	// several task durations.
+	if stats.QueueWait.P90 < taskDuration {
+		t.Errorf("QueueWait.P90: got %v, want >= %v", stats.QueueWait.P90, taskDuration)
+	}
+
+	var shardTotal uint64
+	for _, ss := range stats.Shards {
+		shardTotal += ss.Execution.Count
+	}
+	if shardTotal != numTasks {
+		t.Errorf("sum of per-shard execution samples: got %d, want %d", shardTotal, numTasks)
+	}
+}
+
+func TestStatsTimingDisabled(t *testing.T) {
+	wp := NewWorkerPool(func(task int) {})
+	wp.SetNumShards(1)
+	wp.Start()
+	defer wp.Stop()
+
+	if err := wp.AddTaskWithBlocking(1); err != nil {
+		t.Fatalf("AddTaskWithBlocking: %v", err)
+	}
+	wp.Wait()
+
+	stats := wp.Stats()
+	if stats.Execution.Count != 0 || stats.QueueWait.Count != 0 || stats.Execution.Quantile(0.5) != 0 {
+		t.Errorf("latency stats without timing mode: got %+v / %+v, want zero values", stats.Execution, stats.QueueWait)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"sync/atomic"
+)
+
+// Enables work stealing: a worker that runs out of tasks takes one from a
+// sibling shard before it parks, and a shard that builds up a backlog at its
+// worker cap wakes a parked worker of a sibling to do so. AddTask picks the
+// less loaded of two random shards, falling back to the other one before it
+// returns ErrPoolOverload. This evens out the imbalance of random placement
+// (e.g. a slow task holding up its shard's backlog) at the cost of
+// per-shard FIFO order. Stolen tasks are accounted to the shard they were
+// queued in. Must be called before Start.
+func (wp *WorkerPool[T]) SetWorkStealing(enabled bool) {
+	wp.checkMutable("SetWorkStealing")
+	wp.workStealing = enabled
+}
+
+// dispatchTwoChoices dispatches a task to the less loaded of two random
+// shards and falls back to the other one if the first is full
+func (wp *WorkerPool[T]) dispatchTwoChoices(shards []*poolShard[T], lane int, qt QueuedTask[T], countRejected bool) error {
+	n := len(shards)
+	r := randInt()
+	i := r % n
+	j := (i + 1 + (r/n)%(n-1)) % n
+
+	first, second := shards[i], shards[j]
+	if second.laneLen(lane) < first.laneLen(lane) {
+		first, second = second, first
+	}
+
+	// Only the second shard counts a rejection, as the first one's is not
+	// final.
+	if err := first.dispatchCounted(lane, qt, false); err != ErrPoolOverload {
+		return err
+	}
+
+	return second.dispatchCounted(lane, qt, countRejected)
+}
+
+// laneLen returns the number of tasks buffered in the given lane
+func (shard *poolShard[T]) laneLen(lane int) int {
+	if shard.queue != nil {
+		return shard.queue.lane(lane).Len()
+	}
+
+	return len(shard.laneQueue(lane))
+}
+
+// nudgeSibling wakes a parked worker of a random sibling shard, which then
+// tries to steal the backlog of this one
+func (shard *poolShard[T]) nudgeSibling() {
+	shards := shard.wp.shards.Load().shards
+	if len(shards) < 2 {
+		return
+	}
+
+	sibling := shards[randInt()%len(shards)]
+	if sibling == shard {
+		sibling = shards[(shard.index+1)%len(shards)]
+	}
+	select {
+	case sibling.wake <- struct{}{}:
+	default:
+	}
+}
+
+// steal takes a task from the queue of a sibling shard, scanning them from a
+// random one on. Returns the shard the task was queued in, or nil.
+func (shard *poolShard[T]) steal(turn *int) (*poolShard[T], QueuedTask[T]) {
+	var qt QueuedTask[T]
+
+	shards := shard.wp.shards.Load().shards
+	n := len(shards)
+	start := randInt()
+	for i := 0; i < n; i++ {
+		victim := shards[(start+i)%n]
+		if victim == shard {
+			continue
+		}
+
+		// A closed queue (the pool is stopping) has nothing to steal; its
+		// own workers drain it.
+		var ok bool
+		if victim.queues != nil {
+			qt, ok, _ = victim.queues.poll(turn)
+		} else {
+			select {
+			case qt, ok = <-victim.taskQueue:
+			default:
+			}
+		}
+		if ok {
+			atomic.AddUint64(&victim.stolen, 1)
+			return victim, qt
+		}
+	}
+
+	return nil, qt
+}
@@ -0,0 +1,112 @@
+package ultrapool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkStealing(t *testing.T) {
+	const numTasks = 10
+
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	done := make(chan int, numTasks)
+	wp := NewWorkerPool(func(task int) {
+		if task < 0 {
+			close(blocked)
+			<-release
+			return
+		}
+		done <- task
+	})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.SetWorkStealing(true)
+	wp.Start()
+	defer wp.Stop()
+	defer close(release)
+
+	// Block a worker and queue a backlog behind it on its shard.
+	shard := wp.shards.Load().shards[0]
+	if err := shard.dispatch(laneNormal, -1); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	<-blocked
+	victim := 0
+	if wp.Stats().Shards[0].Stolen > 0 {
+		// shard 1 got to the blocking task first
+		victim = 1
+		shard = wp.shards.Load().shards[1]
+	}
+	for i := 0; i < numTasks; i++ {
+		if err := shard.dispatch(laneNormal, i); err != nil {
+			t.Fatalf("dispatch(%d): %v", i, err)
+		}
+	}
+
+	// The worker of the other shard is woken and works off the backlog.
+	for i := 0; i < numTasks; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of %d tasks ran while shard 0 was blocked", i, numTasks)
+		}
+	}
+
+	stats := wp.Stats()
+	if got := stats.Shards[victim].Stolen; got < numTasks {
+		t.Errorf("stolen tasks: got %d, want >= %d", got, numTasks)
+	}
+	// Stolen tasks count as completed by the shard they were queued in.
+	if got := stats.Shards[victim].Completed; got < numTasks {
+		t.Errorf("completed tasks: got %d, want >= %d", got, numTasks)
+	}
+}
+
+func TestWorkStealingTwoChoices(t *testing.T) {
+	const queueSize = 16
+
+	var running int32
+	release := make(chan struct{})
+	wp := NewWorkerPool(func(task int) {
+		atomic.AddInt32(&running, 1)
+		<-release
+	})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(1)
+	wp.SetShardMaxWorkers(1)
+	wp.SetQueueSize(queueSize)
+	wp.SetWorkStealing(true)
+	wp.Start()
+	defer wp.Stop()
+	defer close(release)
+
+	// Engage both workers, so nobody steals.
+	for _, shard := range wp.shards.Load().shards {
+		if err := shard.dispatch(laneNormal, -1); err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&running) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// With a fallback to the second shard, the pool only rejects once both
+	// queues are full.
+	for i := 0; i < 2*queueSize; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask(%d): %v", i, err)
+		}
+	}
+	if err := wp.AddTask(-2); err != ErrPoolOverload {
+		t.Errorf("AddTask on a full pool: got %v, want ErrPoolOverload", err)
+	}
+
+	// A rejection is only counted once, by the fallback shard.
+	if got := wp.Stats().Rejected; got != 1 {
+		t.Errorf("rejected tasks: got %d, want 1", got)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2019-2026 Moritz Fain
+// Moritz Fain <moritz@fain.io>
+
+package ultrapool
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const defaultAutoTuningInterval = 10 * time.Second
+
+// AutoTuning configures the background tuner that adapts a running pool to
+// the CPUs it may use (see SetAutoTuning).
+type AutoTuning struct {
+	// How often GOMAXPROCS and the cgroup CPU quota are re-evaluated;
+	// defaults to 10s.
+	Interval time.Duration
+
+	// Global worker cap per usable CPU; the per-shard cap follows as an even
+	// share of it. With 0 (the default) only the number of shards is tuned
+	// and the worker limits are left alone.
+	MaxWorkersPerCPU int
+
+	// Called after every decision that changed the pool or could not be
+	// applied. Runs on the tuner goroutine.
+	OnDecision func(d TuningDecision)
+}
+
+// TuningDecision reports what the tuner observed and what it changed.
+type TuningDecision struct {
+	GOMAXPROCS int     // runtime.GOMAXPROCS(0) at the time of the decision
+	CPUQuota   float64 // cgroup CPU quota in CPUs; 0 without a quota
+	CPUs       int     // usable CPUs: GOMAXPROCS, capped by the rounded-up quota
+
+	OldNumShards int
+	OldConfig    Config
+	NumShards    int
+	Config       Config
+
+	// Set if the new limits were rejected; the pool is left unchanged then.
+	Err error
+}
+
+// readCPUQuota is replaced in tests
+var readCPUQuota = func() (float64, bool) {
+	return cgroupCPUQuota(os.DirFS("/"))
+}
+
+// Enables a background tuner that periodically re-evaluates GOMAXPROCS and
+// the Linux cgroup (v1 or v2) CPU quota, and rebalances the number of shards
+// and, optionally, the worker caps accordingly. The tuner starts with the
+// pool, evaluates right away and stops with it. It overrides SetNumShards
+// (and SetMaxWorkers/SetShardMaxWorkers with MaxWorkersPerCPU set); the
+// per-shard floor stays as configured. Must be called before Start; nil
+// disables tuning.
+func (wp *WorkerPool[T]) SetAutoTuning(at *AutoTuning) {
+	wp.checkMutable("SetAutoTuning")
+	wp.autoTuning = at
+}
+
+// runTuner is the tuner goroutine started by Start
+func (wp *WorkerPool[T]) runTuner(at AutoTuning) {
+	interval := at.Interval
+	if interval <= 0 {
+		interval = defaultAutoTuningInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		wp.tune(&at)
+
+		select {
+		case <-ticker.C:
+		case <-wp.stopChan:
+			return
+		}
+	}
+}
+
+// tune makes a single tuning decision and applies it
+func (wp *WorkerPool[T]) tune(at *AutoTuning) {
+	d := TuningDecision{GOMAXPROCS: runtime.GOMAXPROCS(0)}
+	d.CPUs = d.GOMAXPROCS
+	if quota, ok := readCPUQuota(); ok {
+		d.CPUQuota = quota
+		if cpus := int(math.Ceil(quota)); cpus < d.CPUs {
+			d.CPUs = cpus
+		}
+	}
+	if d.CPUs < 1 {
+		d.CPUs = 1
+	}
+
+	wp.mutex.Lock()
+
+	d.OldNumShards, d.OldConfig = wp.numShards, wp.config()
+	d.NumShards, d.Config = numShardsForCPUs(d.CPUs), d.OldConfig
+	if at.MaxWorkersPerCPU > 0 {
+		cfg := &d.Config
+		cfg.MaxWorkers = d.CPUs * at.MaxWorkersPerCPU
+		if floors := cfg.ShardMinWorkers * d.NumShards; cfg.MaxWorkers < floors {
+			cfg.MaxWorkers = floors
+		}
+		cfg.ShardMaxWorkers = (cfg.MaxWorkers + d.NumShards - 1) / d.NumShards
+	}
+
+	if atomic.LoadInt32(&wp.stopped) != 0 || (d.NumShards == d.OldNumShards && d.Config == d.OldConfig) {
+		wp.mutex.Unlock()
+		return
+	}
+
+	// Apply the limits before resizing, so new shards start with the new
+	// ones.
+	d.Err = d.Config.validate(d.NumShards)
+	if d.Err == nil {
+		wp.applyConfig(d.Config)
+		wp.resizeShards(d.NumShards)
+	}
+
+	wp.mutex.Unlock()
+
+	if at.OnDecision != nil {
+		at.OnDecision(d)
+	}
+}
@@ -0,0 +1,100 @@
+package ultrapool
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// setCPUQuota fakes the cgroup CPU quota for the duration of the test
+func setCPUQuota(t *testing.T, quota float64) {
+	old := readCPUQuota
+	readCPUQuota = func() (float64, bool) {
+		return quota, quota > 0
+	}
+	t.Cleanup(func() { readCPUQuota = old })
+}
+
+func TestAutoTuning(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(16))
+	setCPUQuota(t, 7.5)
+
+	decisions := make(chan TuningDecision, 10)
+	at := &AutoTuning{
+		Interval:         time.Hour,
+		MaxWorkersPerCPU: 10,
+		OnDecision: func(d TuningDecision) {
+			decisions <- d
+		},
+	}
+
+	wp := NewWorkerPool(func(task int) {})
+	wp.SetNumShards(1)
+	wp.SetShardMinWorkers(1)
+	wp.SetAutoTuning(at)
+	wp.Start()
+	defer wp.Stop()
+
+	// The tuner evaluates right away.
+	var d TuningDecision
+	select {
+	case d = <-decisions:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no decision after Start")
+	}
+	if d.Err != nil {
+		t.Fatalf("decision error: %v", d.Err)
+	}
+	if d.CPUQuota != 7.5 || d.CPUs != 8 {
+		t.Errorf("decision CPUs: got quota %v and %d CPUs, want 7.5 and 8", d.CPUQuota, d.CPUs)
+	}
+	if d.OldNumShards != 1 || d.NumShards != 4 {
+		t.Errorf("decision shards: got %d -> %d, want 1 -> 4", d.OldNumShards, d.NumShards)
+	}
+	if d.Config.MaxWorkers != 80 || d.Config.ShardMaxWorkers != 20 || d.Config.ShardMinWorkers != 1 {
+		t.Errorf("decision limits: got %+v, want 80 max workers, 20 per shard, floor 1", d.Config)
+	}
+	if got := wp.GetNumShards(); got != 4 {
+		t.Errorf("GetNumShards: got %d, want 4", got)
+	}
+	if got := wp.Config(); got != d.Config {
+		t.Errorf("Config: got %+v, want %+v", got, d.Config)
+	}
+
+	// Nothing changed, nothing to report.
+	wp.tune(at)
+	select {
+	case d = <-decisions:
+		t.Errorf("unexpected decision without a change: %+v", d)
+	default:
+	}
+
+	// The quota shrinks.
+	setCPUQuota(t, 2)
+	wp.tune(at)
+	d = <-decisions
+	if d.CPUs != 2 || d.NumShards != 2 || d.Config.MaxWorkers != 20 || d.Config.ShardMaxWorkers != 10 {
+		t.Errorf("decision after shrinking quota: got %+v", d)
+	}
+	if got := wp.GetNumShards(); got != 2 {
+		t.Errorf("GetNumShards after shrinking quota: got %d, want 2", got)
+	}
+}
+
+func TestAutoTuningKeepsFloors(t *testing.T) {
+	setCPUQuota(t, 1)
+
+	wp := NewWorkerPool(func(task int) {})
+	wp.SetShardMinWorkers(3)
+	wp.Start()
+	defer wp.Stop()
+
+	wp.tune(&AutoTuning{MaxWorkersPerCPU: 1})
+
+	// One worker per CPU would undercut the floors; the global cap is raised
+	// to fit them instead.
+	cfg := wp.Config()
+	if cfg.ShardMinWorkers != 3 || cfg.MaxWorkers != 3*wp.GetNumShards() || cfg.ShardMaxWorkers != 3 {
+		t.Errorf("limits: got %+v with %d shards", cfg, wp.GetNumShards())
+	}
+}
@@ -11,8 +11,10 @@
 package ultrapool
 
 import (
+	"context"
 	"errors"
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,36 +23,173 @@ import (
 var ErrPoolOverload = errors.New("worker pool overloaded")
 var ErrPoolStopped = errors.New("worker pool stopped")
 
+// errShardRemoved makes AddTask retry on the current shard table
+var errShardRemoved = errors.New("shard removed")
+
 type TaskHandlerFunc[T any] func(task T)
 
+// PanicHandlerFunc is called with the task, the recovered value and the
+// goroutine stack whenever a task handler panics.
+type PanicHandlerFunc[T any] func(task T, recovered any, stack []byte)
+
 type WorkerPool[T any] struct {
 	handlerFunc        TaskHandlerFunc[T]
+	newWorker          func(shard int) (TaskHandlerFunc[T], func())
+	batching           *batching[T]
+	limits             atomic.Pointer[poolLimits]
+	panicHandler       PanicHandlerFunc[T]
+	hooks              *Hooks[T]
+	autoTuning         *AutoTuning
+	delayPolicy        DelayPolicy[T]
+	rejection          RejectionPolicy[T]
+	timers             []*timerHeap[T]
+	priorityMode       PriorityMode
+	priorityWeights    [numLanes]int
+	workStealing       bool
+	queueKind          QueueKind
+	queueFactory       QueueFactory[T]
+	overflowPolicy     *OverflowPolicy[T]
+	overflow           *overflow[T]
+	keyFunc            KeyFunc[T]
+	keySerialization   bool
+	keys               *keySerializer[T]
+	taskTiming         bool
 	idleWorkerLifetime time.Duration
 	numShards          int
 	maxWorkers         int
 	queueSize          int
 	shardMinWorkers    int
 	shardMaxWorkers    int
-	shards             []*poolShard[T]
+	shards             atomic.Pointer[shardTable[T]]
 	notify             chan struct{}
 	stopChan           chan struct{}
 	doneChan           chan struct{}
 	doneOnce           sync.Once
 	mutex              sync.Mutex
+	stopMutex          sync.Mutex // serializes Stop, which fires delayed tasks outside of mutex
 	started            bool
+	frozen             bool
 	stopped            int32
 
 	spawnedWorkers uint64
 	_              [56]byte
 
-	waiters uint64
+	waiters   uint64
+	discarded uint64
+
+	idleMutex   sync.Mutex
+	idleChan    chan struct{}
+	idleWaiters int32
 }
 
 type poolShard[T any] struct {
 	wp        *WorkerPool[T]
+	index     int
 	tqLock    sync.RWMutex
-	taskQueue chan T
-	workers   int64
+	taskQueue chan QueuedTask[T] // nil unless the queue kind is QueueChan
+	lanes     *shardLanes[T]     // nil unless priority lanes are enabled
+	queue     *shardQueue[T]     // nil with QueueChan
+	queues    queueSet[T]        // lanes or queue; nil for a plain taskQueue
+	wake      chan struct{}      // nudges a parked worker to steal; nil without work stealing
+	removed   bool               // set under tqLock by ResizeShards, before taskQueue is closed
+	timing    *shardTiming
+	batches   *histogram // batch sizes; nil unless in batched execution mode
+	_         [64]byte
+
+	// workers is CAS'd by every dispatch that finds a backlog
+	workers int64
+	_       [56]byte
+
+	// The counters of rarer events share a cache line of their own, so that
+	// they don't slow down the hot counters. backlogged counts the tasks
+	// waiting in key backlogs.
+	spawned    uint64
+	retired    uint64
+	rejected   uint64
+	stolen     uint64
+	backlogged int64
+	_          [24]byte
+
+	// submitted and completed count accepted and finished tasks; their
+	// difference is the number of queued plus executing tasks.
+	submitted uint64
+	_         [56]byte
+
+	completed uint64
+	_         [56]byte
+}
+
+// QueuedTask is the element of a shard's task queue. It is opaque; custom
+// queues (see SetQueueFactory) store and return it as is.
+type QueuedTask[T any] struct {
+	task T
+
+	// meta is nil unless a feature needs it, so that plain pools queue
+	// nothing but the task and a nil pointer.
+	meta *taskMeta
+}
+
+// taskMeta holds the optional data of a queued task: enqueuedAt is only set
+// in timing mode, key only for keyed tasks in key serialization mode, size
+// only in overflow mode, group only for tasks added via Group.Go. It may be
+// shared by several tasks, so it's copied (see clone) rather than modified
+// once set.
+type taskMeta struct {
+	enqueuedAt int64
+	key        uint64
+	keyed      bool
+	size       int32 // SizeFunc estimate in overflow mode
+	group      *taskGroup
+}
+
+// clone returns a copy of meta to modify, or new meta data if meta is nil
+func (meta *taskMeta) clone() *taskMeta {
+	if meta == nil {
+		return &taskMeta{}
+	}
+	clone := *meta
+	return &clone
+}
+
+func (qt *QueuedTask[T]) enqueuedAt() int64 {
+	if qt.meta == nil {
+		return 0
+	}
+	return qt.meta.enqueuedAt
+}
+
+func (qt *QueuedTask[T]) key() (key uint64, keyed bool) {
+	if qt.meta == nil {
+		return 0, false
+	}
+	return qt.meta.key, qt.meta.keyed
+}
+
+func (qt *QueuedTask[T]) size() int64 {
+	if qt.meta == nil {
+		return 0
+	}
+	return int64(qt.meta.size)
+}
+
+func (qt *QueuedTask[T]) group() *taskGroup {
+	if qt.meta == nil {
+		return nil
+	}
+	return qt.meta.group
+}
+
+// shardTiming holds a shard's latency histograms in timing mode.
+type shardTiming struct {
+	queueWait histogram
+	execution histogram
+}
+
+// monotonic time base for enqueuedAt
+var timeBase = time.Now()
+
+func nanotime() int64 {
+	return int64(time.Since(timeBase))
 }
 
 const defaultIdleWorkerLifetime = time.Second
@@ -63,7 +202,13 @@ const defaultNumShardsMax = 48
 
 // defaultNumShards returns GOMAXPROCS/2, clamped to [defaultNumShardsMin, defaultNumShardsMax].
 func defaultNumShards() int {
-	n := runtime.GOMAXPROCS(0) / 2
+	return numShardsForCPUs(runtime.GOMAXPROCS(0))
+}
+
+// numShardsForCPUs returns cpus/2, clamped to [defaultNumShardsMin, defaultNumShardsMax]
+// and rounded up to an even number.
+func numShardsForCPUs(cpus int) int {
+	n := cpus / 2
 	if n < defaultNumShardsMin {
 		n = defaultNumShardsMin
 	}
@@ -87,6 +232,7 @@ func NewWorkerPool[T any](handlerFunc TaskHandlerFunc[T]) *WorkerPool[T] {
 		queueSize:          defaultQueueSize,
 		shardMinWorkers:    defaultShardMinWorkers,
 		shardMaxWorkers:    defaultShardMaxWorkers,
+		priorityWeights:    [numLanes]int{defaultPriorityWeightHigh, defaultPriorityWeightNormal, defaultPriorityWeightLow},
 	}
 
 	return wp
@@ -94,6 +240,7 @@ func NewWorkerPool[T any](handlerFunc TaskHandlerFunc[T]) *WorkerPool[T] {
 
 // Sets the maximum number of workers that may exist concurrently.
 func (wp *WorkerPool[T]) SetMaxWorkers(n int) {
+	wp.checkMutable("SetMaxWorkers")
 	if n < 0 {
 		n = 0
 	}
@@ -102,6 +249,7 @@ func (wp *WorkerPool[T]) SetMaxWorkers(n int) {
 
 // Sets the per-shard task queue capacity. Values below 16 are clamped to 16.
 func (wp *WorkerPool[T]) SetQueueSize(size int) {
+	wp.checkMutable("SetQueueSize")
 	if size < 16 {
 		size = 16
 	}
@@ -111,6 +259,7 @@ func (wp *WorkerPool[T]) SetQueueSize(size int) {
 // Sets the minimum number of workers per shard that are kept alive when idle.
 // Also used as the initial worker count per shard at Start().
 func (wp *WorkerPool[T]) SetShardMinWorkers(n int) {
+	wp.checkMutable("SetShardMinWorkers")
 	if n < 1 {
 		n = 1
 	}
@@ -121,6 +270,7 @@ func (wp *WorkerPool[T]) SetShardMinWorkers(n int) {
 // Acts as a per-shard backpressure cap independent of (and additional to)
 // the global SetMaxWorkers cap. Values <= 0 reset to defaultShardMaxWorkers.
 func (wp *WorkerPool[T]) SetShardMaxWorkers(n int) {
+	wp.checkMutable("SetShardMaxWorkers")
 	if n <= 0 {
 		n = defaultShardMaxWorkers
 	}
@@ -130,6 +280,7 @@ func (wp *WorkerPool[T]) SetShardMaxWorkers(n int) {
 // Sets number of shards. Values <= 0 reset to the runtime-derived default
 // (GOMAXPROCS/4, clamped to [defaultNumShardsMin, defaultNumShardsMax]).
 func (wp *WorkerPool[T]) SetNumShards(numShards int) {
+	wp.checkMutable("SetNumShards")
 	if numShards <= 0 {
 		numShards = defaultNumShards()
 	}
@@ -141,9 +292,27 @@ func (wp *WorkerPool[T]) SetNumShards(numShards int) {
 
 // Sets the idle worker lifetime
 func (wp *WorkerPool[T]) SetIdleWorkerLifetime(d time.Duration) {
+	wp.checkMutable("SetIdleWorkerLifetime")
 	wp.idleWorkerLifetime = d
 }
 
+// Sets a handler that is called when a task handler panics. With a panic
+// handler set, the worker recovers, reports the panic and continues with the
+// next task; without one (the default), a panicking task crashes the process.
+func (wp *WorkerPool[T]) SetPanicHandler(handler PanicHandlerFunc[T]) {
+	wp.checkMutable("SetPanicHandler")
+	wp.panicHandler = handler
+}
+
+// Enables timing mode: every task's queue wait (from dispatch until a worker
+// picks it up) and execution time are recorded in per-shard histograms,
+// which Stats reports. Must be called before Start. Disabled by default; it
+// costs two clock reads and a small allocation per task when enabled.
+func (wp *WorkerPool[T]) SetTaskTiming(enabled bool) {
+	wp.checkMutable("SetTaskTiming")
+	wp.taskTiming = enabled
+}
+
 // Returns the number of currently spawned workers
 func (wp *WorkerPool[T]) GetSpawnedWorkers() int {
 	return int(atomic.LoadUint64(&wp.spawnedWorkers))
@@ -151,6 +320,10 @@ func (wp *WorkerPool[T]) GetSpawnedWorkers() int {
 
 // Returns the number of shards
 func (wp *WorkerPool[T]) GetNumShards() int {
+	if table := wp.shards.Load(); table != nil {
+		return len(table.shards)
+	}
+
 	return wp.numShards
 }
 
@@ -171,31 +344,150 @@ func (wp *WorkerPool[T]) Start() {
 	wp.stopChan = make(chan struct{})
 	wp.doneChan = make(chan struct{})
 	wp.doneOnce = sync.Once{}
+	wp.limits.Store(wp.config().limits())
 
+	wp.overflow = nil
+	if wp.overflowPolicy != nil {
+		wp.overflow = newOverflow(wp, *wp.overflowPolicy)
+	}
+
+	// Stats reads the timers once the shard table is published.
+	wp.timers = make([]*timerHeap[T], wp.numShards)
+	for i := range wp.timers {
+		wp.timers[i] = newTimerHeap(wp)
+	}
+
+	table := &shardTable[T]{}
 	for i := 0; i < wp.numShards; i++ {
-		shard := &poolShard[T]{
-			wp:        wp,
-			taskQueue: make(chan T, wp.queueSize),
+		table.shards = append(table.shards, wp.startShard(i))
+	}
+	wp.shards.Store(table)
+
+	if wp.keySerialization {
+		wp.keys = newKeySerializer[T]()
+	}
+
+	if wp.autoTuning != nil {
+		go wp.runTuner(*wp.autoTuning)
+	}
+
+	wp.started = true
+}
+
+// startShard creates a shard along with its initial (floor) workers
+func (wp *WorkerPool[T]) startShard(index int) *poolShard[T] {
+	shard := &poolShard[T]{
+		wp:    wp,
+		index: index,
+	}
+	if wp.queueKind == QueueChan && wp.queueFactory == nil && wp.overflow == nil {
+		shard.taskQueue = make(chan QueuedTask[T], wp.queueSize)
+		shard.lanes = wp.newLanes(shard.taskQueue)
+		if shard.lanes != nil {
+			shard.queues = shard.lanes
 		}
-		wp.shards = append(wp.shards, shard)
+	} else {
+		shard.queue = wp.newShardQueue()
+		shard.queues = shard.queue
+	}
+	if wp.workStealing {
+		shard.wake = make(chan struct{}, 1)
+	}
+	if wp.taskTiming {
+		shard.timing = &shardTiming{}
+	}
+	if wp.batching != nil {
+		shard.batches = &histogram{}
+	}
 
-		// Start initial workers per shard
-		for j := 0; j < wp.shardMinWorkers; j++ {
-			shard.spawnWorker()
+	for j := 0; j < wp.shardMinWorkers; j++ {
+		shard.spawnWorker()
+	}
+
+	return shard
+}
+
+// Blocks until every task submitted so far has finished executing. Unlike
+// StopAndWait, the pool keeps running and accepts new tasks meanwhile.
+func (wp *WorkerPool[T]) Wait() {
+	_ = wp.WaitContext(context.Background())
+}
+
+// Blocks until every task submitted so far has finished executing or ctx is
+// done. Returns ctx.Err() if ctx is done first.
+func (wp *WorkerPool[T]) WaitContext(ctx context.Context) error {
+	// Register before inspecting the counters: a worker finishing the last
+	// task either sees the waiter and signals, or we see its completion.
+	atomic.AddInt32(&wp.idleWaiters, 1)
+	defer atomic.AddInt32(&wp.idleWaiters, -1)
+
+	wp.idleMutex.Lock()
+	if wp.inflight() == 0 {
+		wp.idleMutex.Unlock()
+		return nil
+	}
+	if wp.idleChan == nil {
+		wp.idleChan = make(chan struct{})
+	}
+	idleChan := wp.idleChan
+	wp.idleMutex.Unlock()
+
+	select {
+	case <-idleChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// inflight returns the number of queued plus executing tasks, including
+// those of removed shards that still drain. completed is read before
+// submitted so the result can only overestimate.
+func (wp *WorkerPool[T]) inflight() int64 {
+	table := wp.shards.Load()
+	if table == nil {
+		return 0
+	}
+
+	var n int64
+	for _, shards := range [][]*poolShard[T]{table.shards, table.removed} {
+		for _, shard := range shards {
+			completed := atomic.LoadUint64(&shard.completed)
+			n += int64(atomic.LoadUint64(&shard.submitted) - completed)
 		}
 	}
 
-	wp.started = true
+	return n
+}
+
+func (wp *WorkerPool[T]) signalIdle() {
+	wp.idleMutex.Lock()
+	if wp.idleChan != nil && wp.inflight() == 0 {
+		close(wp.idleChan)
+		wp.idleChan = nil
+	}
+	wp.idleMutex.Unlock()
 }
 
 // Stops the worker pool
 func (wp *WorkerPool[T]) Stop() {
-	wp.mutex.Lock()
-	defer wp.mutex.Unlock()
+	wp.stopMutex.Lock()
+	defer wp.stopMutex.Unlock()
 
+	wp.mutex.Lock()
 	if !wp.started || atomic.LoadInt32(&wp.stopped) != 0 {
+		wp.mutex.Unlock()
 		return
 	}
+	wp.mutex.Unlock()
+
+	// Fire or drop pending delayed tasks while the queues still accept them.
+	// Firing may wait for a saturated pool to drain, so it must not hold
+	// wp.mutex: the tuner and the handlers may need it meanwhile.
+	wp.stopTimers()
+
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
 
 	atomic.StoreInt32(&wp.stopped, 1)
 	close(wp.stopChan)
@@ -205,13 +497,20 @@ func (wp *WorkerPool[T]) Stop() {
 	// dispatchers acquire RLock after this point and see wp.stopped == 1, so
 	// they bail with ErrPoolStopped before touching the channel. Workers
 	// drain buffered tasks and then see !ok on their next receive and exit.
-	for _, shard := range wp.shards {
+	// Removed shards are closed already.
+	for _, shard := range wp.shards.Load().shards {
 		shard.tqLock.Lock()
-		close(shard.taskQueue)
+		shard.closeQueues()
 		shard.tqLock.Unlock()
 	}
 }
 
+// Returns a channel that is closed once the pool is stopped; nil before
+// Start
+func (wp *WorkerPool[T]) Stopped() <-chan struct{} {
+	return wp.stopChan
+}
+
 // Stops the worker pool and blocks until all workers have exited.
 func (wp *WorkerPool[T]) StopAndWait() {
 	wp.Stop()
@@ -230,29 +529,89 @@ func (wp *WorkerPool[T]) StopWithTimeout(timeout time.Duration) bool {
 	}
 }
 
-// Adds a new task
+// Adds a new task. Returns ErrPoolOverload if its shard's queue is full,
+// unless the rejection policy says otherwise (see SetRejectionPolicy).
 func (wp *WorkerPool[T]) AddTask(task T) error {
+	return wp.submit(laneNormal, task)
+}
+
+// addTask adds a task to the given priority lane of a random shard. A
+// refusal with ErrPoolOverload only counts as rejected if countRejected is
+// set; callers that retry or fall back to a rejection policy count it once
+// the outcome is final.
+func (wp *WorkerPool[T]) addTask(lane int, task T, countRejected bool) error {
+	return wp.addQueued(lane, QueuedTask[T]{task: task}, countRejected)
+}
+
+// addQueued is addTask for a task along with its meta data
+func (wp *WorkerPool[T]) addQueued(lane int, qt QueuedTask[T], countRejected bool) error {
 	if !wp.started {
 		return errors.New("worker pool must be started first")
 	}
 	if atomic.LoadInt32(&wp.stopped) != 0 {
 		return ErrPoolStopped
 	}
+	if wp.keyFunc != nil {
+		return wp.addQueuedKeyed(lane, wp.keyFunc(qt.task), qt, countRejected)
+	}
+
+	// A shard removed by ResizeShards after we loaded the table rejects the
+	// task with errShardRemoved; the next load sees the new table.
+	for {
+		var err error
+		shards := wp.shards.Load().shards
+		if wp.workStealing && len(shards) > 1 {
+			err = wp.dispatchTwoChoices(shards, lane, qt, countRejected)
+		} else {
+			err = shards[randInt()%len(shards)].dispatchCounted(lane, qt, countRejected)
+		}
+		if err != errShardRemoved {
+			return err
+		}
+	}
+}
+
+// Adds a new task unless ctx is already done
+func (wp *WorkerPool[T]) AddTaskContext(ctx context.Context, task T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	shard := wp.shards[randInt()%wp.numShards]
-	return shard.dispatch(task)
+	return wp.AddTask(task)
 }
 
 // Adds a new task and blocks until submitted
 func (wp *WorkerPool[T]) AddTaskWithBlocking(task T) error {
-	err := wp.AddTask(task)
-	if err == nil || err != ErrPoolOverload {
+	return wp.AddTaskWithBlockingContext(context.Background(), task)
+}
+
+// Adds a new task and blocks until submitted, ctx is done or the pool is
+// stopped. Returns ctx.Err() on cancellation and ErrPoolStopped if the pool
+// stops while waiting.
+func (wp *WorkerPool[T]) AddTaskWithBlockingContext(ctx context.Context, task T) error {
+	return wp.addTaskWithBlocking(ctx, laneNormal, task)
+}
+
+func (wp *WorkerPool[T]) addTaskWithBlocking(ctx context.Context, lane int, task T) error {
+	return wp.blockOnOverload(ctx, func() error {
+		return wp.addTask(lane, task, false)
+	})
+}
+
+// blockOnOverload calls add until it no longer fails with ErrPoolOverload,
+// waiting for a worker to free up queue space in between
+func (wp *WorkerPool[T]) blockOnOverload(ctx context.Context, add func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := add()
+	if err != ErrPoolOverload {
 		return err
 	}
 
 	atomic.AddUint64(&wp.waiters, 1)
 	for {
-		err = wp.AddTask(task)
+		err = add()
 		if err == nil {
 			n := atomic.AddUint64(&wp.waiters, ^uint64(0))
 			if n > 0 {
@@ -272,62 +631,125 @@ func (wp *WorkerPool[T]) AddTaskWithBlocking(task T) error {
 		case <-wp.notify:
 		case <-wp.stopChan:
 			atomic.AddUint64(&wp.waiters, ^uint64(0))
-			return errors.New("worker pool stopped")
+			return ErrPoolStopped
+		case <-ctx.Done():
+			atomic.AddUint64(&wp.waiters, ^uint64(0))
+			return ctx.Err()
 		}
 	}
 }
 
-// dispatch enqueues a task and spawns a worker on visible backlog.
-// The RLock fences the entire critical section (both send attempts and the
-// spawn calls) against Stop's close of taskQueue. Late dispatchers re-check
-// wp.stopped under the lock to close the TOCTOU window between AddTask's
-// fast-path check and the actual send. A non-zero len() after a successful
-// send means no idle worker grabbed the task directly, so it would have to
-// wait — spawn one (capped).
-func (shard *poolShard[T]) dispatch(task T) error {
-	if len(shard.taskQueue) > 0 {
-		//shard.trySpawnWorker()
-	}
+// dispatch enqueues a task into the given priority lane and spawns a worker
+// on visible backlog. The RLock fences the entire critical section (both
+// send attempts and the spawn calls) against the close of the queues by Stop
+// or ResizeShards. Late dispatchers re-check wp.stopped and shard.removed
+// under the lock to close the TOCTOU window between AddTask's fast-path
+// check and the actual send. A non-zero len() after a successful send means
+// no idle worker grabbed the task directly, so it would have to wait — spawn
+// one (capped).
+func (shard *poolShard[T]) dispatch(lane int, task T) error {
+	return shard.dispatchCounted(lane, QueuedTask[T]{task: task}, true)
+}
 
+// dispatchCounted is dispatch with the option not to count a rejection
+func (shard *poolShard[T]) dispatchCounted(lane int, qt QueuedTask[T], countRejected bool) error {
+	queue := shard.laneQueue(lane)
 	shard.tqLock.RLock()
 
 	if atomic.LoadInt32(&shard.wp.stopped) != 0 {
 		shard.tqLock.RUnlock()
 		return ErrPoolStopped
 	}
+	if shard.removed {
+		shard.tqLock.RUnlock()
+		return errShardRemoved
+	}
 
-	select {
-	case shard.taskQueue <- task:
-		if len(shard.taskQueue) > 0 {
-			shard.trySpawnWorker()
+	if shard.timing != nil {
+		qt.meta = qt.meta.clone()
+		qt.meta.enqueuedAt = nanotime()
+	}
+
+	// Count the task before it becomes visible to workers, so completed can
+	// never overtake submitted.
+	atomic.AddUint64(&shard.submitted, 1)
+
+	if shard.send(queue, lane, qt) {
+		if shard.hasBacklog(queue) && !shard.trySpawnWorker() && shard.wake != nil {
+			shard.nudgeSibling()
 		}
 
 		shard.tqLock.RUnlock()
 		return nil
-	default:
 	}
 
 	// buffer full — spawn and retry once
 	shard.trySpawnWorker()
 
 	// retry a non-blocking enqueue; a worker may have drained the buffer after trySpawnWorker.
-	select {
-	case shard.taskQueue <- task:
+	if shard.send(queue, lane, qt) {
+		if shard.wake != nil {
+			shard.nudgeSibling()
+		}
 		shard.tqLock.RUnlock()
 		return nil
+	}
+
+	// Settle the counters while still holding the lock, so they are final
+	// once a removed shard has drained.
+	submitted := atomic.AddUint64(&shard.submitted, ^uint64(0))
+	if countRejected {
+		atomic.AddUint64(&shard.rejected, 1)
+	}
+	shard.tqLock.RUnlock()
+	shard.checkIdle(submitted, atomic.LoadUint64(&shard.completed))
+	return ErrPoolOverload
+}
+
+// laneQueue returns the channel of the given lane, or nil if the shard's
+// queue kind is not QueueChan
+func (shard *poolShard[T]) laneQueue(lane int) chan QueuedTask[T] {
+	if shard.lanes != nil {
+		return shard.lanes.queues[lane]
+	}
+
+	return shard.taskQueue
+}
+
+// send enqueues a task into the given lane (whose channel is queue) without
+// blocking
+func (shard *poolShard[T]) send(queue chan QueuedTask[T], lane int, qt QueuedTask[T]) bool {
+	if shard.queue != nil {
+		return shard.queue.push(lane, qt)
+	}
+
+	select {
+	case queue <- qt:
+		return true
 	default:
-		shard.tqLock.RUnlock()
-		return ErrPoolOverload
+		return false
 	}
 }
 
+// hasBacklog reports queued tasks that no parked worker is about to take. A
+// channel hands a sent task directly to a parked receiver, so a non-empty
+// channel has a backlog.
+func (shard *poolShard[T]) hasBacklog(queue chan QueuedTask[T]) bool {
+	if shard.queue != nil {
+		return shard.queue.backlog() > 0
+	}
+
+	return len(queue) > 0
+}
+
 // trySpawnWorker attempts to spawn a new worker for this shard, respecting both
-// the per-shard cap (shardMaxWorkers) and the global cap (wp.maxWorkers).
+// the per-shard cap (shardMaxWorkers) and the global cap (maxWorkers).
 // Both bounds are enforced atomically via CAS to prevent TOCTOU over-spawn
 // when many dispatchers race the spawn decision.
 func (shard *poolShard[T]) trySpawnWorker() bool {
 	wp := shard.wp
-	shardMax := int64(wp.shardMaxWorkers)
+	limits := wp.limits.Load()
+	shardMax := limits.shardMaxWorkers
 	// Reserve a per-shard slot atomically.
 	for {
 		cur := atomic.LoadInt64(&shard.workers)
@@ -340,10 +762,10 @@ func (shard *poolShard[T]) trySpawnWorker() bool {
 	}
 
 	// Reserve a global slot atomically (or unconditional add when no cap).
-	if wp.maxWorkers > 0 {
+	if limits.maxWorkers > 0 {
 		for {
 			cur := atomic.LoadUint64(&wp.spawnedWorkers)
-			if cur >= uint64(wp.maxWorkers) {
+			if cur >= uint64(limits.maxWorkers) {
 				// Roll back the per-shard reservation.
 				atomic.AddInt64(&shard.workers, -1)
 				return false
@@ -356,6 +778,7 @@ func (shard *poolShard[T]) trySpawnWorker() bool {
 		atomic.AddUint64(&wp.spawnedWorkers, 1)
 	}
 
+	atomic.AddUint64(&shard.spawned, 1)
 	go shard.workerLoop()
 	return true
 }
@@ -365,29 +788,70 @@ func (shard *poolShard[T]) trySpawnWorker() bool {
 func (shard *poolShard[T]) spawnWorker() {
 	atomic.AddUint64(&shard.wp.spawnedWorkers, 1)
 	atomic.AddInt64(&shard.workers, 1)
+	atomic.AddUint64(&shard.spawned, 1)
 	go shard.workerLoop()
 }
 
 // workerLoop is the main worker goroutine. It reads from its shard's
-// taskQueue. Workers above the per-shard floor exit after idleWorkerLifetime
-// without receiving a task. On Stop, taskQueue is closed: buffered values
-// drain first, then receives return !ok and the worker exits.
+// taskQueue (or its priority lanes). Workers above the per-shard floor exit
+// after idleWorkerLifetime without receiving a task. On Stop (or removal of
+// the shard), taskQueue is closed: buffered values drain first, then
+// receives return !ok and the worker exits.
 func (shard *poolShard[T]) workerLoop() {
 	wp := shard.wp
-	idleTimeout := wp.idleWorkerLifetime
+	limits := wp.limits.Load()
+	lanes := shard.queues
+	turn := 0
 	var idleTimer *time.Timer
+	exitReason := WorkerExitStop
+
+	// Each worker may bring its own handler (bound to per-worker state) and a
+	// release func for that state.
+	handler, release := wp.handlerFunc, func() {}
+	if wp.newWorker != nil {
+		handler, release = wp.newWorker(shard.index)
+	}
+
+	// In batched execution mode, each worker reuses its own batch.
+	var batch *taskBatch[T]
+	if wp.batching != nil {
+		batch = wp.batching.newBatch()
+		handler = batch.runOne
+	}
+
+	if wp.hooks != nil && wp.hooks.OnWorkerStart != nil {
+		wp.hooks.OnWorkerStart(shard.index)
+	}
 
 	for {
 		// Run queued work first; without any timer overhead. A closed channel
 		// drains buffered values before returning !ok, so this naturally
 		// handles "drain remaining tasks before exiting" on Stop.
 		for {
+			if lanes != nil {
+				qt, ok, closed := lanes.poll(&turn)
+				if closed {
+					goto exit
+				}
+				if !ok {
+					goto idle
+				}
+				shard.runTask(handler, batch, qt)
+				if shard.retireOnReconfigure(&limits) {
+					goto retire
+				}
+				continue
+			}
+
 			select {
-			case task, ok := <-shard.taskQueue:
+			case qt, ok := <-shard.taskQueue:
 				if !ok {
 					goto exit
 				}
-				wp.handlerFunc(task)
+				shard.runTask(handler, batch, qt)
+				if shard.retireOnReconfigure(&limits) {
+					goto retire
+				}
 			default:
 				goto idle
 			}
@@ -395,55 +859,136 @@ func (shard *poolShard[T]) workerLoop() {
 
 	idle:
 		wp.notifyWaiter()
+		if shard.retireOnReconfigure(&limits) {
+			goto retire
+		}
+
+		if wp.workStealing {
+			if victim, qt := shard.steal(&turn); victim != nil {
+				victim.runTask(handler, batch, qt)
+				if shard.retireOnReconfigure(&limits) {
+					goto retire
+				}
+				continue
+			}
+		}
 
 		// Floor workers wait indefinitely to keep the shard warm. Plain
 		// chanrecv (the compiler skips selectgo for a single-case receive).
-		if atomic.LoadInt64(&shard.workers) <= int64(wp.shardMinWorkers) {
-			task, ok := <-shard.taskQueue
+		if atomic.LoadInt64(&shard.workers) <= limits.shardMinWorkers {
+			if lanes != nil {
+				// A closed lane sends us back to the poll loop, which
+				// drains the other lanes before exiting.
+				qt, ok, _ := lanes.wait(nil, shard.wake)
+				if !ok {
+					continue
+				}
+				shard.runTask(handler, batch, qt)
+				if shard.retireOnReconfigure(&limits) {
+					goto retire
+				}
+				continue
+			}
+
+			if shard.wake != nil {
+				// A nudge from a backlogged sibling sends us back to the
+				// idle path to steal.
+				select {
+				case qt, ok := <-shard.taskQueue:
+					if !ok {
+						goto exit
+					}
+					shard.runTask(handler, batch, qt)
+					if shard.retireOnReconfigure(&limits) {
+						goto retire
+					}
+				case <-shard.wake:
+				}
+				continue
+			}
+
+			qt, ok := <-shard.taskQueue
 			if !ok {
 				goto exit
 			}
-			wp.handlerFunc(task)
+			shard.runTask(handler, batch, qt)
+			if shard.retireOnReconfigure(&limits) {
+				goto retire
+			}
 			continue
 		}
 
 		// Workers above the floor may retire after an idle timeout.
 		if idleTimer == nil {
-			idleTimer = time.NewTimer(idleTimeout)
+			idleTimer = time.NewTimer(limits.idleWorkerLifetime)
 		} else {
-			idleTimer.Reset(idleTimeout)
+			idleTimer.Reset(limits.idleWorkerLifetime)
 		}
 
-		select {
-		case task, ok := <-shard.taskQueue:
-			if !idleTimer.Stop() {
-				// drain stale value (not required for Go 1.23+)
-				select {
-				case <-idleTimer.C:
-				default:
-				}
+		if lanes != nil {
+			qt, ok, timedOut := lanes.wait(idleTimer.C, shard.wake)
+			if timedOut {
+				goto timeout
 			}
+			stopTimer(idleTimer)
+			if !ok {
+				continue
+			}
+			shard.runTask(handler, batch, qt)
+			if shard.retireOnReconfigure(&limits) {
+				goto retire
+			}
+			continue
+		}
+
+		select {
+		case qt, ok := <-shard.taskQueue:
+			stopTimer(idleTimer)
 			if !ok {
 				goto exit
 			}
-			wp.handlerFunc(task)
+			shard.runTask(handler, batch, qt)
+			if shard.retireOnReconfigure(&limits) {
+				goto retire
+			}
+			continue
+		case <-shard.wake:
+			stopTimer(idleTimer)
+			continue
 		case <-idleTimer.C:
-			for {
-				workers := atomic.LoadInt64(&shard.workers)
-				if workers <= int64(wp.shardMinWorkers) {
-					break
-				}
-				// Only exit if the decrement keeps the shard at or above its floor.
-				if atomic.CompareAndSwapInt64(&shard.workers, workers, workers-1) {
-					goto exit2
-				}
+		}
+
+	timeout:
+		limits = wp.limits.Load()
+		for {
+			workers := atomic.LoadInt64(&shard.workers)
+			if workers <= limits.shardMinWorkers {
+				break
+			}
+			// Only exit if the decrement keeps the shard at or above its floor.
+			if atomic.CompareAndSwapInt64(&shard.workers, workers, workers-1) {
+				atomic.AddUint64(&shard.retired, 1)
+				exitReason = WorkerExitIdle
+				goto exit2
 			}
 		}
 	}
 
 exit:
+	if shard.removed {
+		exitReason = WorkerExitRemoved
+	}
 	atomic.AddInt64(&shard.workers, -1)
+	goto exit2
+retire:
+	exitReason = WorkerExitExcess
 exit2:
+	// Run the exit hook while the worker still counts as spawned, so
+	// StopAndWait returns only after all exit hooks did.
+	if wp.hooks != nil && wp.hooks.OnWorkerExit != nil {
+		wp.hooks.OnWorkerExit(shard.index, exitReason)
+	}
+	release()
 	atomic.AddUint64(&wp.spawnedWorkers, ^uint64(0))
 	wp.notifyWaiter()
 	if atomic.LoadInt32(&wp.stopped) != 0 && atomic.LoadUint64(&wp.spawnedWorkers) == 0 {
@@ -451,6 +996,111 @@ exit2:
 	}
 }
 
+// stopTimer stops a timer whose channel was not received from
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		// drain stale value (not required for Go 1.23+)
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// runTask executes a dequeued task and accounts for its completion. In
+// batched execution mode, it runs the task in a batch along with the ones
+// queued behind it. In key serialization mode, it then runs the tasks with
+// the same key that were added meanwhile.
+func (shard *poolShard[T]) runTask(handler TaskHandlerFunc[T], batch *taskBatch[T], qt QueuedTask[T]) {
+	if batch != nil {
+		shard.runBatch(handler, batch, qt)
+		return
+	}
+
+	shard.run(handler, qt)
+	if key, keyed := qt.key(); keyed {
+		shard.wp.keys.runBacklog(handler, key)
+	}
+}
+
+// run executes a task and accounts for its completion. Tasks of a canceled
+// group are skipped.
+func (shard *poolShard[T]) run(handler TaskHandlerFunc[T], qt QueuedTask[T]) {
+	group := qt.group()
+	if group == nil || !group.skip() {
+		var recovered any
+		if shard.timing == nil && shard.wp.hooks == nil {
+			recovered = shard.wp.execute(handler, qt.task)
+		} else {
+			recovered = shard.runObserved(handler, qt)
+		}
+		if group != nil {
+			group.finish(recovered)
+		}
+	}
+
+	completed := atomic.AddUint64(&shard.completed, 1)
+	shard.checkIdle(atomic.LoadUint64(&shard.submitted), completed)
+}
+
+// runObserved executes a task with timing mode and/or task hooks: it records
+// queue wait and execution time and calls OnTaskStart/OnTaskDone. Returns
+// the recovered panic value, if any.
+func (shard *poolShard[T]) runObserved(handler TaskHandlerFunc[T], qt QueuedTask[T]) any {
+	wp := shard.wp
+	start := nanotime()
+	if shard.timing != nil {
+		shard.timing.queueWait.record(start - qt.enqueuedAt())
+	}
+	if wp.hooks != nil && wp.hooks.OnTaskStart != nil {
+		wp.hooks.OnTaskStart(shard.index, qt.task)
+	}
+
+	recovered := wp.execute(handler, qt.task)
+
+	duration := nanotime() - start
+	if shard.timing != nil {
+		shard.timing.execution.record(duration)
+	}
+	if wp.hooks != nil && wp.hooks.OnTaskDone != nil {
+		wp.hooks.OnTaskDone(shard.index, qt.task, time.Duration(duration), recovered)
+	}
+
+	return recovered
+}
+
+// checkIdle wakes Wait callers once this shard has no task in flight (and
+// only if anyone is waiting at all).
+func (shard *poolShard[T]) checkIdle(submitted, completed uint64) {
+	if submitted == completed && atomic.LoadInt32(&shard.wp.idleWaiters) != 0 {
+		shard.wp.signalIdle()
+	}
+}
+
+// execute runs the handler on task and returns the recovered panic value, if
+// any. Recovery is only set up when a panic handler is configured, keeping
+// the default path free of defers.
+func (wp *WorkerPool[T]) execute(handler TaskHandlerFunc[T], task T) any {
+	if wp.panicHandler == nil {
+		handler(task)
+		return nil
+	}
+
+	return wp.executeRecover(handler, task)
+}
+
+func (wp *WorkerPool[T]) executeRecover(handler TaskHandlerFunc[T], task T) (recovered any) {
+	defer func() {
+		if r := recover(); r != nil {
+			recovered = r
+			wp.panicHandler(task, r, debug.Stack())
+		}
+	}()
+
+	handler(task)
+	return nil
+}
+
 func (wp *WorkerPool[T]) notifyWaiter() {
 	if atomic.LoadUint64(&wp.waiters) == 0 {
 		return
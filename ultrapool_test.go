@@ -1,6 +1,7 @@
 package ultrapool
 
 import (
+	"context"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -159,6 +160,14 @@ func TestShardWorkerBoundsApplied(t *testing.T) {
 func engageBlockedPool(t *testing.T, shardMax, queueSize int, idleLifetime time.Duration) (*WorkerPool[int], chan struct{}, func()) {
 	t.Helper()
 
+	return engageBlockedPoolWith(t, shardMax, queueSize, idleLifetime, nil)
+}
+
+// engageBlockedPoolWith is engageBlockedPool with a configure func that is
+// applied before Start (may be nil).
+func engageBlockedPoolWith(t *testing.T, shardMax, queueSize int, idleLifetime time.Duration, configure func(wp *WorkerPool[int])) (*WorkerPool[int], chan struct{}, func()) {
+	t.Helper()
+
 	release := make(chan struct{})
 	var unblock sync.Once
 	releaseAll := func() { unblock.Do(func() { close(release) }) }
@@ -173,6 +182,9 @@ func engageBlockedPool(t *testing.T, shardMax, queueSize int, idleLifetime time.
 	wp.SetShardMaxWorkers(shardMax)
 	wp.SetQueueSize(queueSize)
 	wp.SetIdleWorkerLifetime(idleLifetime)
+	if configure != nil {
+		configure(wp)
+	}
 	wp.Start()
 
 	// Feed shardMax priming tasks so that one worker per task is engaged.
@@ -276,6 +288,132 @@ func TestAddTaskWithBlockingWaits(t *testing.T) {
 	case <-time.After(2 * time.Second):
 		t.Fatal("AddTaskWithBlocking never unblocked after pressure was relieved")
 	}
+
+	// Only the probe was refused; the retries of the blocking add don't count.
+	if got := wp.Stats().Rejected; got != 1 {
+		t.Errorf("Stats.Rejected: got %d, want 1", got)
+	}
+}
+
+func TestAddTaskWithBlockingContextCancel(t *testing.T) {
+	const queueSize = 16
+	const shardMax = 2
+
+	wp, _, releaseAll := engageBlockedPool(t, shardMax, queueSize, time.Hour)
+	defer wp.Stop()
+	defer releaseAll()
+
+	for i := 0; i < queueSize; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask buffer fill %d: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := wp.AddTaskWithBlockingContext(ctx, 1234)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("AddTaskWithBlockingContext on saturated pool: got %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("AddTaskWithBlockingContext returned after %v; expected ~50ms", elapsed)
+	}
+	if got := atomic.LoadUint64(&wp.waiters); got != 0 {
+		t.Errorf("waiters after cancellation: got %d, want 0", got)
+	}
+
+	// An already canceled context never submits.
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err := wp.AddTaskContext(canceled, 1); err != context.Canceled {
+		t.Errorf("AddTaskContext with canceled ctx: got %v, want context.Canceled", err)
+	}
+}
+
+func TestAddTaskWithBlockingStopped(t *testing.T) {
+	const queueSize = 16
+	const shardMax = 2
+
+	wp, _, releaseAll := engageBlockedPool(t, shardMax, queueSize, time.Hour)
+	defer releaseAll()
+
+	for i := 0; i < queueSize; i++ {
+		if err := wp.AddTask(i); err != nil {
+			t.Fatalf("AddTask buffer fill %d: %v", i, err)
+		}
+	}
+
+	blockingDone := make(chan error, 1)
+	go func() {
+		blockingDone <- wp.AddTaskWithBlocking(1234)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	wp.Stop()
+
+	select {
+	case err := <-blockingDone:
+		if err != ErrPoolStopped {
+			t.Errorf("AddTaskWithBlocking after Stop: got %v, want ErrPoolStopped", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("AddTaskWithBlocking never returned after Stop")
+	}
+	if got := atomic.LoadUint64(&wp.waiters); got != 0 {
+		t.Errorf("waiters after Stop: got %d, want 0", got)
+	}
+}
+
+func TestPanicHandlerRecovers(t *testing.T) {
+	const numTasks = 100
+
+	var completed int64
+	var panics int64
+	var lastStack atomic.Value
+
+	wp := NewWorkerPool(func(task int) {
+		if task%10 == 0 {
+			panic("bad input")
+		}
+		atomic.AddInt64(&completed, 1)
+	})
+	wp.SetNumShards(2)
+	wp.SetShardMinWorkers(2)
+	wp.SetShardMaxWorkers(4)
+	wp.SetPanicHandler(func(task int, recovered any, stack []byte) {
+		if task%10 != 0 {
+			t.Errorf("panic handler called for task %d", task)
+		}
+		if recovered != "bad input" {
+			t.Errorf("recovered value: got %v, want %q", recovered, "bad input")
+		}
+		lastStack.Store(stack)
+		atomic.AddInt64(&panics, 1)
+	})
+	wp.Start()
+
+	for i := 0; i < numTasks; i++ {
+		if err := wp.AddTaskWithBlocking(i); err != nil {
+			t.Fatalf("AddTaskWithBlocking(%d): %v", i, err)
+		}
+	}
+
+	wp.StopAndWait()
+
+	if got := atomic.LoadInt64(&panics); got != numTasks/10 {
+		t.Errorf("panics: got %d, want %d", got, numTasks/10)
+	}
+	if got := atomic.LoadInt64(&completed); got != numTasks-numTasks/10 {
+		t.Errorf("completed tasks: got %d, want %d", got, numTasks-numTasks/10)
+	}
+	if stack, _ := lastStack.Load().([]byte); len(stack) == 0 {
+		t.Error("panic handler received an empty stack")
+	}
+	if got := wp.GetSpawnedWorkers(); got != 0 {
+		t.Errorf("spawned workers after StopAndWait: got %d, want 0", got)
+	}
 }
 
 func TestTaskCompletenessAcrossConfigs(t *testing.T) {
@@ -472,6 +610,72 @@ func TestWorkerScaling(t *testing.T) {
 	}
 }
 
+func TestWaitBarrier(t *testing.T) {
+	const numTasks = 50
+
+	var completed int64
+
+	wp := NewWorkerPool(func(task int) {
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&completed, 1)
+	})
+	wp.SetNumShards(4)
+	wp.SetShardMaxWorkers(4)
+	wp.Start()
+	defer wp.Stop()
+
+	// Waiting on an idle pool returns immediately.
+	wp.Wait()
+
+	for phase := 1; phase <= 3; phase++ {
+		for i := 0; i < numTasks; i++ {
+			if err := wp.AddTaskWithBlocking(i); err != nil {
+				t.Fatalf("phase %d: AddTaskWithBlocking(%d): %v", phase, i, err)
+			}
+		}
+
+		wp.Wait()
+
+		if got := atomic.LoadInt64(&completed); got != int64(phase*numTasks) {
+			t.Fatalf("phase %d: completed tasks after Wait: got %d, want %d", phase, got, phase*numTasks)
+		}
+		if got := wp.inflight(); got != 0 {
+			t.Fatalf("phase %d: in-flight tasks after Wait: got %d, want 0", phase, got)
+		}
+	}
+}
+
+func TestWaitContextTimeout(t *testing.T) {
+	const queueSize = 16
+	const shardMax = 2
+
+	wp, _, releaseAll := engageBlockedPool(t, shardMax, queueSize, time.Hour)
+	defer wp.Stop()
+	defer releaseAll()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := wp.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitContext on busy pool: got %v, want context.DeadlineExceeded", err)
+	}
+
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- wp.WaitContext(context.Background())
+	}()
+
+	releaseAll()
+
+	select {
+	case err := <-waitDone:
+		if err != nil {
+			t.Errorf("WaitContext after release: got %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("WaitContext never returned after the pool drained")
+	}
+}
+
 func TestStopAndWait(t *testing.T) {
 	const numTasks = 50
 	const taskDuration = 50 * time.Millisecond